@@ -0,0 +1,53 @@
+package httpjson
+
+import (
+	"encoding/base64"
+	"io"
+)
+
+// A base64Reader reads base64 encoded data from r, translating the
+// URL-safe alphabet to the standard alphabet and removing any padding
+// from the end, so that the result can be decoded using
+// base64.RawStdEncoding.
+type base64Reader struct {
+	r io.Reader
+
+	// n is the number of bytes read from r.
+	n int64
+
+	// padded is set once padding has been read, after which only more
+	// padding or line breaks may follow.
+	padded bool
+}
+
+// Read implements io.Reader.
+func (b *base64Reader) Read(p []byte) (int, error) {
+	for {
+		n, err := b.r.Read(p)
+		j := 0
+		for i, c := range p[:n] {
+			switch c {
+			case '=':
+				b.padded = true
+				continue
+			case '\r', '\n':
+			default:
+				if b.padded {
+					return j, base64.CorruptInputError(b.n + int64(i))
+				}
+			}
+			switch c {
+			case '-':
+				c = '+'
+			case '_':
+				c = '/'
+			}
+			p[j] = c
+			j++
+		}
+		b.n += int64(n)
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package httpjson
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Cache stores the bodies of successful responses so that a Client can
+// reuse them. A Cache must be safe for concurrent use.
+type Cache interface {
+	// Get returns the body and header stored with the given key, if
+	// there is one.
+	Get(key string) (body []byte, header http.Header, ok bool)
+
+	// Set stores the body and header with the given key, replacing any
+	// existing entry. The entry is fresh for ttl, after which the
+	// Client revalidates it with the server before it is used again if
+	// the header contains an ETag or Last-Modified header, so a Cache
+	// should keep entries for longer than ttl where it can. A Cache may
+	// discard any entry at any time.
+	Set(key string, body []byte, header http.Header, ttl time.Duration)
+}
+
+// A cacheEntry is a response body retrieved from a Cache.
+type cacheEntry struct {
+	key    string
+	body   []byte
+	header http.Header
+}
+
+// cacheLookup finds the entry in the cache for req, if there is one.
+// Responses with a Vary header are stored with a marker entry, keyed by
+// the URL and credentials, that contains the Vary header, the response
+// itself is stored with a key that also contains the values of the
+// varying request headers.
+func (c *Client) cacheLookup(req *http.Request) *cacheEntry {
+	key := cacheKey(req)
+	body, header, ok := c.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+	if vary := header.Values("Vary"); len(vary) > 0 {
+		key = varyKey(key, vary, req.Header)
+		if body, header, ok = c.Cache.Get(key); !ok {
+			return nil
+		}
+	}
+	return &cacheEntry{key: key, body: body, header: header}
+}
+
+// cacheStore stores the body of resp, the successful response to req,
+// in the cache if the response allows it. The body of resp is replaced
+// with a copy of the body that was read.
+func (c *Client) cacheStore(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	ttl, ok := freshLifetime(resp.Header)
+	if !ok || (ttl <= 0 && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return nil
+	}
+	vary := resp.Header.Values("Vary")
+	for _, f := range headerFields(vary) {
+		if f == "*" {
+			return nil
+		}
+	}
+	body, err := io.ReadAll(limit(resp.Body, c.MaxResponseBytes, ErrResponseTooLarge))
+	if err != nil {
+		return err
+	}
+	resp.Body = readCloser{Reader: bytes.NewReader(body), Closer: resp.Body}
+	header := resp.Header.Clone()
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	key := cacheKey(req)
+	if len(vary) > 0 {
+		c.Cache.Set(key, nil, http.Header{"Vary": vary}, ttl)
+		key = varyKey(key, vary, req.Header)
+	}
+	c.Cache.Set(key, body, header, ttl)
+	return nil
+}
+
+// cacheRevalidated updates the cached entry using the headers from the
+// 304 (Not Modified) response resp and returns the response to use in
+// its place.
+func (c *Client) cacheRevalidated(req *http.Request, entry *cacheEntry, resp *http.Response) *http.Response {
+	header := entry.header.Clone()
+	for _, k := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+		if v := resp.Header.Values(k); len(v) > 0 {
+			header[k] = v
+		}
+	}
+	if resp.Header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	ttl, _ := freshLifetime(header)
+	c.Cache.Set(entry.key, entry.body, header, ttl)
+	entry.header = header
+	return entry.response(req)
+}
+
+// fresh determines whether the entry can be used without revalidation
+// at the given time.
+func (e *cacheEntry) fresh(now time.Time) bool {
+	date, err := http.ParseTime(e.header.Get("Date"))
+	if err != nil {
+		return false
+	}
+	ttl, _ := freshLifetime(e.header)
+	return now.Sub(date) < ttl
+}
+
+// addValidators makes req conditional on the entry having been
+// modified, unless req is already conditional.
+func (e *cacheEntry) addValidators(req *http.Request) {
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+	if etag := e.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+}
+
+// response creates a successful response to req from the entry.
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// freshLifetime determines how long a response with the given header is
+// fresh for, and whether it may be stored at all, from its Cache-Control
+// and Expires headers.
+func freshLifetime(h http.Header) (time.Duration, bool) {
+	cc := cacheControl(h)
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return 0, true
+	}
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, true
+		}
+		if n > math.MaxInt64/int64(time.Second) {
+			n = math.MaxInt64 / int64(time.Second)
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	expires, err := http.ParseTime(h.Get("Expires"))
+	if err != nil {
+		return 0, true
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	if d := expires.Sub(date); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// cacheControl parses the directives in the Cache-Control header of h.
+func cacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			var v string
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				d, v = d[:i], d[i+1:]
+			}
+			cc[strings.ToLower(strings.TrimSpace(d))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return cc
+}
+
+// credentialHeaders are the request headers that identify the caller, a
+// response to a request with any of them is only reused for requests
+// with the same values.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// cacheKey creates the cache key for the response to req, from its URL
+// and the credentials it carries, which may come from Client.Header,
+// Client.BearerToken or ContextWithHeader. The credentials are hashed,
+// so that they are not stored in the Cache.
+func cacheKey(req *http.Request) string {
+	key := req.URL.String()
+	h := sha256.New()
+	found := false
+	for _, f := range credentialHeaders {
+		for _, v := range req.Header.Values(f) {
+			found = true
+			io.WriteString(h, f+": "+v+"\n")
+		}
+	}
+	if !found {
+		return key
+	}
+	return key + "\ncredentials: " + hex.EncodeToString(h.Sum(nil))
+}
+
+// varyKey creates the cache key for the response to a request for url
+// with the given header, where the response varies by the given fields.
+func varyKey(url string, vary []string, h http.Header) string {
+	var sb strings.Builder
+	sb.WriteString(url)
+	for _, f := range headerFields(vary) {
+		sb.WriteString("\n")
+		sb.WriteString(f)
+		sb.WriteString(": ")
+		sb.WriteString(strings.Join(h.Values(f), ", "))
+	}
+	return sb.String()
+}
+
+// headerFields returns the sorted, canonicalized, field names listed in
+// the given header values.
+func headerFields(values []string) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		for _, f := range strings.Split(v, ",") {
+			f = http.CanonicalHeaderKey(strings.TrimSpace(f))
+			if f == "" || seen[f] {
+				continue
+			}
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
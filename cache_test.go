@@ -0,0 +1,280 @@
+package httpjson_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+// memoryCache is a Cache that stores entries in memory, ignoring their
+// ttl.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	body   []byte
+	header http.Header
+	ttl    time.Duration
+}
+
+func (c *memoryCache) Get(key string) ([]byte, http.Header, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.body, e.header, ok
+}
+
+func (c *memoryCache) Set(key string, body []byte, header http.Header, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]memoryCacheEntry)
+	}
+	c.entries[key] = memoryCacheEntry{body: body, header: header, ttl: ttl}
+}
+
+// cacheHandler serves a testValue containing the request path and the
+// number of requests served, setting the response headers using
+// setHeader.
+type cacheHandler struct {
+	mu        sync.Mutex
+	requests  []*http.Request
+	setHeader func(w http.ResponseWriter, req *http.Request) bool
+}
+
+func (h *cacheHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	h.requests = append(h.requests, req)
+	n := len(h.requests)
+	h.mu.Unlock()
+	if !h.setHeader(w, req) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: req.URL.Path + " " + req.Header.Get("Accept-Language") + " " + string(rune('0'+n))})
+}
+
+func TestClientCacheHit(t *testing.T) {
+	h := &cacheHandler{
+		setHeader: func(w http.ResponseWriter, _ *http.Request) bool {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			return true
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cache := new(memoryCache)
+	cl := httpjson.Client{Cache: cache}
+	for i := 0; i < 3; i++ {
+		var resp testValue
+		err := cl.Get(context.Background(), srv.URL+"/a", &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, "/a  1")
+	}
+	qt.Check(t, h.requests, qt.HasLen, 1)
+	qt.Check(t, cache.entries[srv.URL+"/a"].ttl, qt.Equals, 60*time.Second)
+
+	// DoRaw uses the same cached body.
+	buf, err := cl.DoRaw(context.Background(), "GET", srv.URL+"/a", "", nil, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"/a  1"}`)
+	qt.Check(t, h.requests, qt.HasLen, 1)
+}
+
+func TestClientCacheRevalidate(t *testing.T) {
+	h := &cacheHandler{
+		setHeader: func(w http.ResponseWriter, req *http.Request) bool {
+			w.Header().Set("ETag", `"v1"`)
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				w.Header().Set("Cache-Control", "max-age=60")
+				return false
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			return true
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cache := new(memoryCache)
+	cl := httpjson.Client{Cache: cache}
+	for i := 0; i < 3; i++ {
+		var resp testValue
+		err := cl.Get(context.Background(), srv.URL+"/a", &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, "/a  1")
+	}
+	// The first request populates the cache, the second revalidates
+	// the stale entry and the third uses the refreshed entry.
+	qt.Assert(t, h.requests, qt.HasLen, 2)
+	qt.Check(t, h.requests[0].Header.Get("If-None-Match"), qt.Equals, "")
+	qt.Check(t, h.requests[1].Header.Get("If-None-Match"), qt.Equals, `"v1"`)
+	entry := cache.entries[srv.URL+"/a"]
+	qt.Check(t, entry.ttl, qt.Equals, 60*time.Second)
+	qt.Check(t, entry.header.Get("Cache-Control"), qt.Equals, "max-age=60")
+	qt.Check(t, entry.header.Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+}
+
+func TestClientCacheRevalidateLastModified(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	h := &cacheHandler{
+		setHeader: func(w http.ResponseWriter, req *http.Request) bool {
+			w.Header().Set("Last-Modified", lastModified)
+			return req.Header.Get("If-Modified-Since") != lastModified
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{Cache: new(memoryCache)}
+	for i := 0; i < 2; i++ {
+		var resp testValue
+		err := cl.Get(context.Background(), srv.URL+"/a", &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, "/a  1")
+	}
+	qt.Assert(t, h.requests, qt.HasLen, 2)
+	qt.Check(t, h.requests[1].Header.Get("If-Modified-Since"), qt.Equals, lastModified)
+}
+
+func TestClientCacheMiss(t *testing.T) {
+	h := &cacheHandler{
+		setHeader: func(w http.ResponseWriter, req *http.Request) bool {
+			switch req.URL.Path {
+			case "/no-store":
+				w.Header().Set("Cache-Control", "no-store")
+			case "/no-validator":
+				w.Header().Set("Cache-Control", "max-age=0")
+			case "/vary-all":
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Vary", "*")
+			case "/expired":
+				w.Header().Set("Expires", "0")
+			default:
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+			return true
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cache := new(memoryCache)
+	cl := httpjson.Client{Cache: cache}
+	paths := []string{"/a", "/b", "/no-store", "/no-store", "/no-validator", "/no-validator", "/vary-all", "/vary-all", "/expired", "/expired"}
+	for i, path := range paths {
+		var resp testValue
+		err := cl.Get(context.Background(), srv.URL+path, &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, path+"  "+string(rune('1'+i)))
+	}
+	qt.Check(t, h.requests, qt.HasLen, len(paths))
+	qt.Check(t, cache.entries, qt.HasLen, 2)
+
+	// Requests other than GET are not cached.
+	var resp testValue
+	err := cl.Do(context.Background(), "POST", srv.URL+"/a", "", testValue{}, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, h.requests, qt.HasLen, len(paths)+1)
+}
+
+func TestClientCacheVary(t *testing.T) {
+	h := &cacheHandler{
+		setHeader: func(w http.ResponseWriter, _ *http.Request) bool {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "accept-language")
+			return true
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{Cache: new(memoryCache)}
+	get := func(lang string) string {
+		ctx := httpjson.ContextWithHeader(context.Background(), http.Header{"Accept-Language": {lang}})
+		var resp testValue
+		err := cl.Get(ctx, srv.URL+"/a", &resp)
+		qt.Assert(t, err, qt.IsNil)
+		return resp.S
+	}
+	qt.Check(t, get("en"), qt.Equals, "/a en 1")
+	qt.Check(t, get("fr"), qt.Equals, "/a fr 2")
+	qt.Check(t, get("en"), qt.Equals, "/a en 1")
+	qt.Check(t, get("fr"), qt.Equals, "/a fr 2")
+	qt.Check(t, h.requests, qt.HasLen, 2)
+}
+
+func TestClientCacheCredentials(t *testing.T) {
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tokens = append(tokens, req.Header.Get("Authorization"))
+		w.Header().Set("Cache-Control", "max-age=60")
+		httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: "for " + req.Header.Get("Authorization")})
+	}))
+	defer srv.Close()
+
+	type tokenKey struct{}
+	cache := new(memoryCache)
+	cl := httpjson.Client{
+		Cache: cache,
+		BearerToken: func(ctx context.Context) (string, error) {
+			return ctx.Value(tokenKey{}).(string), nil
+		},
+	}
+	get := func(token string) string {
+		ctx := context.WithValue(context.Background(), tokenKey{}, token)
+		var resp testValue
+		err := cl.Get(ctx, srv.URL+"/a", &resp)
+		qt.Assert(t, err, qt.IsNil)
+		return resp.S
+	}
+	qt.Check(t, get("alice"), qt.Equals, "for Bearer alice")
+	qt.Check(t, get("bob"), qt.Equals, "for Bearer bob")
+	qt.Check(t, get("alice"), qt.Equals, "for Bearer alice")
+	qt.Check(t, get("bob"), qt.Equals, "for Bearer bob")
+	qt.Check(t, tokens, qt.DeepEquals, []string{"Bearer alice", "Bearer bob"})
+
+	// The credentials are not stored in the cache keys.
+	for key := range cache.entries {
+		qt.Check(t, strings.Contains(key, "alice"), qt.IsFalse)
+		qt.Check(t, strings.Contains(key, "bob"), qt.IsFalse)
+	}
+
+	// A context header is also a credential.
+	ctx := httpjson.ContextWithHeader(context.WithValue(context.Background(), tokenKey{}, "alice"), http.Header{"Authorization": {"Bearer carol"}})
+	var resp testValue
+	err := cl.Get(ctx, srv.URL+"/a", &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "for Bearer carol")
+}
+
+func TestClientCacheBodyLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: strings.Repeat("x", 1<<20)})
+	}))
+	defer srv.Close()
+	cache := new(memoryCache)
+	cl := httpjson.Client{
+		Cache:            cache,
+		MaxResponseBytes: 1024,
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorMatches, `GET http://.*: response body too large`)
+	qt.Check(t, errors.Is(err, httpjson.ErrResponseTooLarge), qt.IsTrue)
+	qt.Check(t, cache.entries, qt.HasLen, 0)
+}
@@ -3,17 +3,25 @@ package httpjson
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
-	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/ianaindex"
 )
 
-// DefaultClient is the client used by Get and Do.
+// DefaultClient is the client used by the package-level request
+// functions.
 var DefaultClient = &Client{}
 
 // Get retrieves a JSON document from the given URL and unmarshals the
@@ -26,7 +34,8 @@ func Get(ctx context.Context, url string, v interface{}) error {
 // Do creates and sends an HTTP request and processes the response. The
 // request has the given method and is addressed to url, if req is not nil
 // then it will be JSON encoded and used as the request body. The content
-// type of the request is specified by contentType, which defaults to
+// type of the request is specified by contentType, which defaults to the
+// content type of a req that implements ContentTyper, or
 // "application/json;charset=utf-8". If the HTTP request results in a valid
 // response that is not a success the resulting error will be of type
 // *ResponseError.
@@ -34,6 +43,94 @@ func Do(ctx context.Context, method, url, contentType string, req, resp interfac
 	return DefaultClient.Do(ctx, method, url, contentType, req, resp)
 }
 
+// Post sends the JSON encoding of req to the given URL in a POST request
+// and unmarshals the response into resp, using DefaultClient.
+func Post(ctx context.Context, url string, req, resp interface{}) error {
+	return DefaultClient.Post(ctx, url, req, resp)
+}
+
+// Put sends the JSON encoding of req to the given URL in a PUT request
+// and unmarshals the response into resp, using DefaultClient.
+func Put(ctx context.Context, url string, req, resp interface{}) error {
+	return DefaultClient.Put(ctx, url, req, resp)
+}
+
+// Patch sends the JSON encoding of req to the given URL in a PATCH
+// request and unmarshals the response into resp, using DefaultClient.
+func Patch(ctx context.Context, url string, req, resp interface{}) error {
+	return DefaultClient.Patch(ctx, url, req, resp)
+}
+
+// Delete sends a DELETE request to the given URL and unmarshals any
+// response body into resp, using DefaultClient.
+func Delete(ctx context.Context, url string, resp interface{}) error {
+	return DefaultClient.Delete(ctx, url, resp)
+}
+
+// DoJSON sends req in the same way as Client.Do and returns the response
+// decoded into a new Resp, so that a typed result is obtained without
+// declaring a variable to decode into. If cl is nil DefaultClient is
+// used, as methods cannot have type parameters the Client is passed as
+// an argument. A nil req, with a Req such as interface{}, sends a
+// request without a body. If the call fails the zero Resp is returned,
+// along with the error that Do would have returned. For example:
+//
+//	item, err := httpjson.DoJSON[NewItem, Item](ctx, client, "POST", url, "", newItem)
+func DoJSON[Req, Resp any](ctx context.Context, cl *Client, method, url, contentType string, req Req) (Resp, error) {
+	if cl == nil {
+		cl = DefaultClient
+	}
+	var resp Resp
+	if err := cl.Do(ctx, method, url, contentType, req, &resp); err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp, nil
+}
+
+type headerKey struct{}
+
+// ContextWithHeader returns a copy of ctx carrying header values that a
+// Client sets on any request made with the returned context. The values
+// replace any header of the same name that would otherwise be sent,
+// allowing headers such as Accept or Accept-Charset to be overridden for
+// a single call, except that the Content-Type and Content-Encoding
+// headers describing an encoded request body are never replaced. If ctx
+// already carries header values then h is merged with them, values in h
+// replacing those with the same name.
+func ContextWithHeader(ctx context.Context, h http.Header) context.Context {
+	h1 := contextHeader(ctx).Clone()
+	if h1 == nil {
+		h1 = make(http.Header)
+	}
+	for k, v := range h {
+		h1[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	return context.WithValue(ctx, headerKey{}, h1)
+}
+
+// contextHeader returns the header values added to ctx by
+// ContextWithHeader.
+func contextHeader(ctx context.Context) http.Header {
+	h, _ := ctx.Value(headerKey{}).(http.Header)
+	return h
+}
+
+// addHeader sets the values in h on the header of req, replacing any
+// existing values, except that if req has a body its Content-Type and
+// Content-Encoding headers, which describe the encoded body, are left
+// unchanged.
+func addHeader(req *http.Request, h http.Header) {
+	body := req.Body != nil && req.Body != http.NoBody
+	for k, v := range h {
+		k = http.CanonicalHeaderKey(k)
+		if body && (k == "Content-Type" || k == "Content-Encoding") {
+			continue
+		}
+		req.Header[k] = append([]string(nil), v...)
+	}
+}
+
 // A Client is an HTTP client that transports JSON-encoded bodies. It's
 // zero value (DefaultClient) is a usable client that uses
 // http.DefaultClient.
@@ -42,10 +139,276 @@ type Client struct {
 	// this is nil http.DefaultClient is used.
 	HTTPClient *http.Client
 
+	// Timeout, if greater than zero, limits the time taken by each
+	// call, including reading the response body. The call's context is
+	// given a deadline of Timeout from the start of the call, if the
+	// context already has an earlier deadline then that deadline is
+	// used instead. For DoStream the timeout continues to apply while
+	// the returned reader is read, until it is closed. Unlike
+	// http.Client.Timeout, the timeout is applied to the context of the
+	// request, so an expired timeout results in an error matching
+	// context.DeadlineExceeded using errors.Is.
+	Timeout time.Duration
+
+	// Header contains headers that are sent with every request, for
+	// example Authorization or User-Agent. A header in Header replaces
+	// one of the same name that the Client would otherwise send, such
+	// as the Accept header, except that the Content-Type and
+	// Content-Encoding headers describing an encoded request body are
+	// never replaced. Headers added to the context of a call with
+	// ContextWithHeader take precedence over Header.
+	Header http.Header
+
 	// IsJSONContentType is used to determine if an HTTP response
 	// contains a JSON-encoded body. If this is nil the
 	// IsJSONContentType function is used.
 	IsJSONContentType func(contentType string) bool
+
+	// DefaultContentType is the content type of request bodies when
+	// no content type is given for a call. A content type given for a
+	// call, either explicitly or by a request value that implements
+	// ContentTyper, is combined with DefaultContentType using
+	// MergeContentType, so a call can, for example, override only the
+	// charset. If this is empty the content type is determined in the
+	// same way as MarshalRequest, using
+	// MarshalOptions.DefaultContentType if it is set.
+	DefaultContentType string
+
+	// Accept is the Accept header sent with every request. If this is
+	// empty DefaultAccept is used, with the media types of any
+	// Decoders added. Whatever is accepted, the body of a successful
+	// response is only decoded if its media type has a decoder or is
+	// JSON according to IsJSONContentType.
+	Accept string
+
+	// Decoders contains functions used to decode the bodies of
+	// successful responses, keyed by lower-case media type, such as
+	// "application/vnd.example+json". A decoder is called with the body
+	// after it has been decoded into UTF-8. Responses with a media type
+	// that has a decoder are accepted regardless of IsJSONContentType.
+	// Responses with any other media type are decoded using
+	// encoding/json. The media types of the decoders are listed in the
+	// default Accept header, after "application/json".
+	Decoders map[string]func(data []byte, v interface{}) error
+
+	// DisallowUnknownFields causes an error to be returned when a JSON
+	// object in a response body contains a key that does not match any
+	// exported field of the struct it is being decoded into. It does
+	// not apply to responses decoded using Decoders.
+	DisallowUnknownFields bool
+
+	// UseNumber causes numbers in response bodies decoded into an
+	// interface{} to be stored as a json.Number rather than a float64,
+	// see UnmarshalOptions.UseNumber. It does not apply to responses
+	// decoded using Decoders.
+	UseNumber bool
+
+	// AllowEmptyBody causes a successful response with an empty body,
+	// or one containing only white space, to leave the value being
+	// decoded into unchanged rather than ErrEmptyBody being returned,
+	// see UnmarshalOptions.AllowEmptyBody. It does not apply to
+	// responses decoded using Decoders.
+	AllowEmptyBody bool
+
+	// SniffCharset causes the character set of a successful response
+	// whose Content-Type has no charset parameter to be detected, rather
+	// than the body being assumed to be UTF-8, see
+	// UnmarshalOptions.SniffCharset. It does not apply to DoStream,
+	// which cannot examine the body before returning it.
+	SniffCharset bool
+
+	// UnmarshalFunc, if not nil, is used to parse response bodies in
+	// place of encoding/json, see UnmarshalOptions.UnmarshalFunc. It
+	// does not apply to responses decoded using Decoders. An
+	// alternative encoder for request bodies can be set with
+	// MarshalOptions.MarshalFunc.
+	UnmarshalFunc func(data []byte, v interface{}) error
+
+	// SendAcceptCharset causes requests to include an Accept-Charset
+	// header containing the charset of the request's content type, so
+	// that a server that negotiates the character set responds in the
+	// same one. If the content type has no charset parameter the header
+	// is not sent.
+	SendAcceptCharset bool
+
+	// MarshalOptions contains the options used to encode request
+	// bodies.
+	MarshalOptions MarshalOptions
+
+	// Base64Body causes the bodies of successful responses to be
+	// base64 decoded before the character set is decoded and the JSON
+	// is parsed, for use with services that base64 encode their JSON
+	// responses. Both the standard and URL-safe alphabets are accepted,
+	// with or without padding, but padding is only accepted at the end
+	// of the body.
+	Base64Body bool
+
+	// MaxResponseBytes is the maximum size of a successful response
+	// body that will be decoded. If the body is compressed the limit
+	// applies to the decompressed body. If a body exceeds this size
+	// ErrResponseTooLarge is returned. The body of an unsuccessful
+	// response is also read up to this size, any more is discarded so
+	// that the resulting ResponseError contains the start of the body.
+	// If this is zero then there is no limit.
+	MaxResponseBytes int64
+
+	// PoolRequestBodies causes request bodies to be encoded into
+	// buffers taken from a pool, the buffer is returned to the pool once
+	// the request has completed. This reduces garbage when making a
+	// large number of requests. A request is complete once the response
+	// body has been closed and the transport has closed every request
+	// body it obtained, including any obtained through GetBody when
+	// following redirects.
+	PoolRequestBodies bool
+
+	// MaxErrorMessageBytes is the maximum length of an error message
+	// taken from the text body of an unsuccessful response by
+	// ResponseError.Error. Longer messages are truncated and end with
+	// an ellipsis. If this is zero a limit of 256 bytes is used.
+	MaxErrorMessageBytes int
+
+	// PreserveErrorMessageSpace stops ResponseError.Error from
+	// removing leading and trailing white space from an error message
+	// taken from the body of an unsuccessful response.
+	PreserveErrorMessageSpace bool
+
+	// FailFastServerErrors causes only the start of the body of a 5xx
+	// response to be read, enough to produce the ResponseError message,
+	// rather than the whole body. The rest of the body is discarded by
+	// closing the connection, trading connection reuse for lower
+	// latency and memory use when a server is returning large error
+	// pages.
+	FailFastServerErrors bool
+
+	// OnTiming, if not nil, is called with the response and the timing
+	// of each request, as soon as the response headers have been
+	// received. It is called for every response, including those that
+	// result in an error.
+	OnTiming func(*http.Response, Timing)
+
+	// OnResponse, if not nil, is called with every response received
+	// by a call before its body is read, whether or not the response
+	// is successful, so that headers such as Location, ETag or Link, or
+	// the status, can be captured or logged. A response served from
+	// Cache is included. OnResponse must not read or close the
+	// response body.
+	OnResponse func(*http.Response)
+
+	// OnRequestBody, if not nil, is called with the body of each
+	// request before it is sent, exactly as it will be sent, so after it
+	// has been encoded into the request's character set and, if
+	// MarshalOptions.GzipMinBytes applies, compressed. The body is a
+	// copy that OnRequestBody may retain. It is not called for requests
+	// without a body, or for a RawBody whose Reader cannot be read
+	// again independently of the body being sent, see RawBody.
+	OnRequestBody func(body []byte)
+
+	// CaptureRequestBody causes the body of the request to be stored
+	// in the RequestBody field of each *ResponseError, so that the
+	// request that caused the error can be reproduced. The body is
+	// exactly as sent, in the same way as for OnRequestBody. It is
+	// obtained from the request once an unsuccessful response has been
+	// received, so no copy is retained by successful calls. A RawBody
+	// that cannot be read more than once is not captured.
+	CaptureRequestBody bool
+
+	// RedactRequestBody, if not nil, is called with each body captured
+	// by CaptureRequestBody and the body that it returns is stored in
+	// the ResponseError instead, so that, for example, credentials can
+	// be removed from it.
+	RedactRequestBody func(body []byte) []byte
+
+	// AcceptStatus, if not nil, is called with the status code of
+	// every response that does not have a 2xx status. If it returns
+	// true the response is processed as a successful response, so its
+	// body is decoded, rather than resulting in an error. For example,
+	// to decode the body of a 404 response:
+	//
+	//	AcceptStatus: func(code int) bool { return code == http.StatusNotFound }
+	AcceptStatus func(statusCode int) bool
+
+	// MapError, if not nil, is called to create the error for an
+	// unsuccessful response instead of creating a *ResponseError. It
+	// is called with the response and its body, which has already been
+	// read in the same way as for a ResponseError, so MapError must not
+	// read resp.Body. To keep the default behaviour for a response
+	// MapError can return &ResponseError{Response: resp, Body: body}.
+	// If MapError returns nil the response is processed as if it were
+	// successful.
+	MapError func(resp *http.Response, body []byte) error
+
+	// ErrorDecoder, if not nil, is called with each *ResponseError
+	// created for an unsuccessful response. A non-nil error that it
+	// returns is stored in the Err field of the ResponseError, so that
+	// it can be recovered from the error returned by the call using
+	// errors.As, allowing an application to define its own errors for
+	// the structured error bodies of a service. The message of the
+	// ResponseError is unchanged. ErrorDecoder is not called for errors
+	// created by MapError.
+	ErrorDecoder func(*ResponseError) error
+
+	// Cache, if not nil, is used to cache the bodies of successful
+	// responses to GET requests without a request body. A fresh cached
+	// response is used without contacting the server, a stale one with
+	// an ETag or Last-Modified header is revalidated with a conditional
+	// request. Freshness is determined from the Cache-Control, Expires
+	// and Date headers of the response. Responses with a Vary header
+	// are cached separately for each combination of values of the
+	// request headers that it names. Responses to requests carrying
+	// credentials, in an Authorization or Cookie header, are cached
+	// separately for each set of credentials, so one caller's response
+	// is never served to a caller with different credentials.
+	Cache Cache
+
+	// Debug causes a dump of every request and response, including
+	// the headers and bodies exactly as sent and received, to be
+	// written to DebugWriter. The whole of each body is read into
+	// memory to produce the dump, so this is intended for local
+	// troubleshooting rather than production use.
+	Debug bool
+
+	// DebugWriter is the writer that Debug output is written to. If
+	// this is nil os.Stderr is used.
+	DebugWriter io.Writer
+
+	// DebugRedactHeaders contains the names of headers whose values
+	// are replaced in Debug output. If this is nil the Authorization,
+	// Proxy-Authorization, Cookie and Set-Cookie headers are redacted.
+	DebugRedactHeaders []string
+
+	// Retry, if not nil, is the policy used to retry requests that
+	// fail with a transient error, see RetryPolicy. If this is nil
+	// requests are not retried.
+	Retry *RetryPolicy
+
+	// BearerToken, if not nil, is called with the context of each call
+	// to obtain a token that is sent in an "Authorization: Bearer"
+	// header, replacing any Authorization header in Header. This allows
+	// tokens to be refreshed as they expire. If BearerToken returns an
+	// error the request is not sent and the error is returned. Like any
+	// Authorization header, the token is redacted from Debug output
+	// and is not sent when following a redirect to a different host.
+	BearerToken func(ctx context.Context) (string, error)
+
+	// RequestIDHeader and RequestIDFromContext propagate a request ID,
+	// such as a correlation ID from a tracing system, stored in the
+	// context of a call. If both are set, RequestIDFromContext is
+	// called with the context of each call and, if it returns a
+	// non-empty ID, the ID is sent in the header named by
+	// RequestIDHeader, replacing any value from Header. Headers added
+	// with ContextWithHeader take precedence.
+	RequestIDHeader      string
+	RequestIDFromContext func(ctx context.Context) string
+
+	// Limiter, if not nil, limits the number of calls that may be in
+	// progress at once, see NewLimiter. A call is in progress from when
+	// its request is sent until the response body has been read and
+	// closed, which for DoStream and Send is when the caller closes it.
+	// A call made when the limit has been reached waits until another
+	// call completes or its context is done, in which case the
+	// context's error is returned. Copies of a Client, and any other
+	// Clients given the same Limiter, share its limit.
+	Limiter *Limiter
 }
 
 // Get retrieves a JSON document from the given URL and unmarshals the
@@ -57,44 +420,639 @@ func (c *Client) Get(ctx context.Context, url string, v interface{}) error {
 
 // Do creates and sends an HTTP request and processes the response. The
 // request has the given method and is addressed to url, if req is not nil
-// then it will be JSON encoded and used as the request body. The content
-// type of the request is specified by contentType, which defaults to
+// then it will be JSON encoded and used as the request body. A req that
+// is a RawBody is sent unchanged instead. The content type of the
+// request is specified by contentType, which defaults to the content
+// type of a req that implements ContentTyper, or
 // "application/json;charset=utf-8". If the HTTP request results in a valid
 // response that is not a success the resulting error will be of type
-// *ResponseError.
+// *ResponseError. If a successful response has a body that does not
+// have a JSON content type the resulting error will be of type
+// *ContentTypeError. Errors encoding the request body, and reading or
+// decoding the response body, are prefixed with the method and URL of
+// the request, and errors with the response body also with the status
+// of the response. The original error remains available through
+// errors.Is and errors.As. A url that is not an http or https URL results in a
+// *SchemeError without a request being sent. Errors sending the
+// request are returned as the *url.Error produced by the http.Client.
+// If resp is nil the
+// response body is not decoded. The context applies to the whole call,
+// including reading the response body, so canceling it aborts a stalled
+// read with an error that matches the context's error using errors.Is.
 func (c *Client) Do(ctx context.Context, method, url, contentType string, req, resp interface{}) error {
-	hreq, err := MarshalRequest(method, url, contentType, req)
+	_, err := c.DoResponse(ctx, method, url, contentType, req, resp)
+	return err
+}
+
+// DoResponse creates and sends an HTTP request and processes the response
+// in the same way as Do, additionally returning the http.Response so
+// that its headers can be inspected. The body of the returned response
+// has already been read and closed, and is replaced with http.NoBody.
+// The response is returned whenever one was received with a successful
+// status, including when the body could not be decoded; an unsuccessful
+// response is available from the returned *ResponseError.
+func (c *Client) DoResponse(ctx context.Context, method, url, contentType string, req, resp interface{}) (*http.Response, error) {
+	hresp, err := c.send(ctx, method, url, contentType, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		err = c.unmarshalResponse(hresp, resp)
+	}
+	hresp.Body.Close()
+	hresp.Body = http.NoBody
+	if err != nil {
+		return hresp, responseBodyError(hresp, err)
+	}
+	return hresp, nil
+}
+
+// Head sends a HEAD request to the given URL and returns the response,
+// so that its status and headers can be inspected. The response has no
+// body and its Content-Type is not checked. If the response is not a
+// success the resulting error will be of type *ResponseError.
+func (c *Client) Head(ctx context.Context, url string) (*http.Response, error) {
+	hresp, err := c.send(ctx, "HEAD", url, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	hresp.Body.Close()
+	hresp.Body = http.NoBody
+	return hresp, nil
+}
+
+// GetIfModified retrieves a JSON document from the given URL, in the
+// same way as DoResponse, if it has changed since a copy was last
+// retrieved. If etag is not empty it is sent in an If-None-Match header,
+// and if lastModified is not zero it is sent in an If-Modified-Since
+// header, these are normally the ETag and Last-Modified headers of the
+// response containing the copy. If the server responds with 304 Not
+// Modified, v is left unchanged and the response is returned with
+// ErrNotModified, so that the copy can continue to be used. The headers
+// of the 304 response, such as a new Cache-Control, remain available.
+func (c *Client) GetIfModified(ctx context.Context, url, etag string, lastModified time.Time, v interface{}) (*http.Response, error) {
+	h := make(http.Header)
+	if etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if !lastModified.IsZero() {
+		h.Set("If-Modified-Since", lastModified.UTC().Format(http.TimeFormat))
+	}
+	hresp, err := c.DoResponse(ContextWithHeader(ctx, h), "GET", url, "", nil, v)
+	var rerr *ResponseError
+	if errors.As(err, &rerr) && rerr.Response.StatusCode == http.StatusNotModified {
+		resp := *rerr.Response
+		resp.Body = http.NoBody
+		return &resp, ErrNotModified
+	}
+	return hresp, err
+}
+
+// Post sends the JSON encoding of req to the given URL in a POST request
+// and unmarshals the response into resp, in the same way as Do.
+func (c *Client) Post(ctx context.Context, url string, req, resp interface{}) error {
+	return c.Do(ctx, "POST", url, "", req, resp)
+}
+
+// Put sends the JSON encoding of req to the given URL in a PUT request
+// and unmarshals the response into resp, in the same way as Do.
+func (c *Client) Put(ctx context.Context, url string, req, resp interface{}) error {
+	return c.Do(ctx, "PUT", url, "", req, resp)
+}
+
+// Patch sends the JSON encoding of req to the given URL in a PATCH
+// request and unmarshals the response into resp, in the same way as Do.
+func (c *Client) Patch(ctx context.Context, url string, req, resp interface{}) error {
+	return c.Do(ctx, "PATCH", url, "", req, resp)
+}
+
+// Delete sends a DELETE request to the given URL and processes the
+// response in the same way as Do. Deleting often produces a response
+// without a body, so if resp is nil, or the response body is empty, resp
+// is left unchanged rather than an error being returned.
+func (c *Client) Delete(ctx context.Context, url string, resp interface{}) error {
+	hresp, err := c.send(ctx, "DELETE", url, "", nil)
 	if err != nil {
 		return err
 	}
+	defer hresp.Body.Close()
+	if resp == nil {
+		return nil
+	}
+	buf, err := c.readResponse(hresp)
+	if err != nil {
+		return responseBodyError(hresp, err)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if err := c.decode(hresp, buf, resp); err != nil {
+		return responseBodyError(hresp, err)
+	}
+	return nil
+}
+
+// DoRaw creates and sends an HTTP request and processes the response in
+// the same way as Do, additionally returning the JSON document that was
+// received. The returned document is exactly as sent by the server,
+// other than having any Content-Encoding removed and being decoded from
+// the response's character set into UTF-8. The document is read once,
+// and is returned even if it cannot be unmarshaled into resp. If resp
+// is nil the document is returned without being unmarshaled.
+func (c *Client) DoRaw(ctx context.Context, method, url, contentType string, req, resp interface{}) ([]byte, error) {
+	hresp, err := c.send(ctx, method, url, contentType, req)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	buf, err := c.readResponse(hresp)
+	if err != nil {
+		return nil, responseBodyError(hresp, err)
+	}
+	if resp == nil {
+		return buf, nil
+	}
+	if err := c.decode(hresp, buf, resp); err != nil {
+		return buf, responseBodyError(hresp, err)
+	}
+	return buf, nil
+}
+
+// DoStream creates and sends an HTTP request in the same way as Do, but
+// rather than decoding the response body it returns a reader from which
+// the body can be read. The returned reader produces the body after any
+// Content-Encoding has been removed and it has been decoded from the
+// character set specified in the response's Content-Type into UTF-8.
+// The MaxResponseBytes limit is applied to the data read.
+//
+// The caller is responsible for closing the returned reader once it has
+// finished with the body, closing the reader closes the response body.
+// The returned http.Response is provided for access to the status and
+// headers, its Body must not be read directly.
+func (c *Client) DoStream(ctx context.Context, method, url, contentType string, req interface{}) (io.ReadCloser, *http.Response, error) {
+	hresp, err := c.send(ctx, method, url, contentType, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := c.responseReader(hresp)
+	if err != nil {
+		hresp.Body.Close()
+		return nil, nil, responseBodyError(hresp, err)
+	}
+	return readCloser{Reader: r, Closer: hresp.Body}, hresp, nil
+}
+
+// Send creates and sends an HTTP request in the same way as Do and
+// returns the response without reading its body, so that the body can
+// be read as it was received, for example to decode it with
+// UnmarshalResponse or to read data that follows the JSON document. An
+// unsuccessful response, or one without a JSON content type, results
+// in an error in the same way as Do, and its body is closed. The body
+// is not decompressed, other than by the http.Client, nor decoded from
+// its character set, and MaxResponseBytes is not applied.
+//
+// The caller is responsible for closing the body of the returned
+// response, which also releases any resources, such as a Timeout, held
+// for the call.
+func (c *Client) Send(ctx context.Context, method, url, contentType string, req interface{}) (*http.Response, error) {
+	return c.send(ctx, method, url, contentType, req)
+}
+
+// send creates and sends an HTTP request, returning the response if it
+// is successful and has a JSON content type. The caller is responsible
+// for closing the response body.
+func (c *Client) send(ctx context.Context, method, url, contentType string, req interface{}) (*http.Response, error) {
+	if err := checkScheme(url); err != nil {
+		return nil, requestError(method, url, err)
+	}
+	if c.Limiter == nil {
+		return c.sendTimeout(ctx, method, url, contentType, req)
+	}
+	select {
+	case c.Limiter.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, requestError(method, url, ctx.Err())
+	}
+	release := func() { <-c.Limiter.sem }
+	hresp, err := c.sendTimeout(ctx, method, url, contentType, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	hresp.Body = &onceCloser{ReadCloser: hresp.Body, f: release}
+	return hresp, nil
+}
+
+// A Limiter limits the number of calls in progress at once for the
+// Clients using it, see Client.Limiter.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter that allows at most n calls to be in
+// progress at once. If n is not greater than zero NewLimiter returns
+// nil, which sets no limit.
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		return nil
+	}
+	return &Limiter{sem: make(chan struct{}, n)}
+}
+
+// sendTimeout sends an HTTP request for send, applying any Timeout.
+func (c *Client) sendTimeout(ctx context.Context, method, url, contentType string, req interface{}) (*http.Response, error) {
+	if c.Timeout <= 0 {
+		return c.sendContext(ctx, method, url, contentType, req)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	hresp, err := c.sendContext(ctx, method, url, contentType, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	hresp.Body = cancelCloser{ReadCloser: hresp.Body, cancel: cancel}
+	return hresp, nil
+}
+
+// sendContext sends an HTTP request for send using the given context,
+// which must remain valid until the response body is closed.
+func (c *Client) sendContext(ctx context.Context, method, url, contentType string, req interface{}) (*http.Response, error) {
+	hreq, body, err := c.marshalRequest(method, url, contentType, req)
+	if err != nil {
+		return nil, requestError(method, url, err)
+	}
+	if c.OnRequestBody != nil && hreq.GetBody != nil {
+		if err := c.onRequestBody(hreq); err != nil {
+			if body != nil {
+				body.release()
+			}
+			return nil, requestError(method, url, err)
+		}
+	}
+	hreq.Header.Set("Accept", c.accept())
+	if c.SendAcceptCharset {
+		// A duplicate charset has already been rejected by
+		// marshalRequest.
+		if charset, _ := contentCharset(c.MarshalOptions.valueContentType(c.contentType(contentType, req), req)); charset != "" {
+			hreq.Header.Set("Accept-Charset", charset)
+		}
+	}
+	addHeader(hreq, c.Header)
+	if c.BearerToken != nil {
+		token, err := c.BearerToken(ctx)
+		if err != nil {
+			if body != nil {
+				body.release()
+			}
+			return nil, requestError(method, url, err)
+		}
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.RequestIDHeader != "" && c.RequestIDFromContext != nil {
+		if id := c.RequestIDFromContext(ctx); id != "" {
+			hreq.Header.Set(c.RequestIDHeader, id)
+		}
+	}
+	addHeader(hreq, contextHeader(ctx))
+	cacheable := c.Cache != nil && hreq.Method == "GET" && req == nil
+	var cached *cacheEntry
+	if cacheable {
+		cached = c.cacheLookup(hreq)
+		if cached != nil {
+			if cached.fresh(time.Now()) {
+				hresp := cached.response(hreq)
+				if c.OnResponse != nil {
+					c.OnResponse(hresp)
+				}
+				return hresp, nil
+			}
+			cached.addValidators(hreq)
+		}
+	}
+	if c.Debug {
+		if err := c.dumpRequest(hreq); err != nil {
+			if body != nil {
+				body.release()
+			}
+			return nil, err
+		}
+	}
+	var tt *timingTrace
+	if c.OnTiming != nil {
+		tt = newTimingTrace()
+		ctx = httptrace.WithClientTrace(ctx, tt.clientTrace())
+	}
 	hreq = hreq.WithContext(ctx)
 	client := c.HTTPClient
 	if client == nil {
 		client = http.DefaultClient
 	}
-	hresp, err := client.Do(hreq)
+	var hresp *http.Response
+	if c.Retry != nil {
+		hresp, err = c.Retry.do(client, hreq)
+	} else {
+		hresp, err = client.Do(hreq)
+	}
+	if err == nil && tt != nil {
+		c.OnTiming(hresp, tt.get())
+	}
+	if err == nil && c.Debug {
+		if derr := c.dumpResponse(hresp); derr != nil {
+			hresp.Body.Close()
+			err = derr
+		}
+	}
+	if body != nil {
+		if err != nil {
+			body.release()
+			return nil, err
+		}
+		hresp.Body = &releaseCloser{ReadCloser: hresp.Body, body: body}
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if cached != nil && hresp.StatusCode == http.StatusNotModified {
+		hresp.Body.Close()
+		hresp = c.cacheRevalidated(hreq, cached, hresp)
+		cacheable = false
+	}
+	if c.OnResponse != nil {
+		c.OnResponse(hresp)
 	}
-	defer hresp.Body.Close()
 
-	if !(200 <= hresp.StatusCode && hresp.StatusCode < 300) {
-		return newResponseError(hresp)
+	if !c.successful(hresp.StatusCode) {
+		if c.MapError == nil {
+			defer hresp.Body.Close()
+			return nil, c.newResponseError(hreq, hresp)
+		}
+		if err := c.mapError(hresp); err != nil {
+			hresp.Body.Close()
+			return nil, err
+		}
 	}
 
 	isJSONContentType := c.IsJSONContentType
 	if isJSONContentType == nil {
 		isJSONContentType = IsJSONContentType
 	}
-	if !isJSONContentType(hresp.Header.Get("Content-Type")) {
-		return fmt.Errorf("unsupported Content-Type %q", hresp.Header.Get("Content-Type"))
+	// A response that is known to have no body has no content to
+	// check the type of.
+	noBody := hresp.StatusCode == http.StatusNoContent || hresp.ContentLength == 0 || hreq.Method == "HEAD"
+	if !noBody && !isJSONContentType(hresp.Header.Get("Content-Type")) && c.decoder(hresp) == nil {
+		defer hresp.Body.Close()
+		return nil, c.newContentTypeError(hresp)
+	}
+	if cacheable {
+		if err := c.cacheStore(hreq, hresp); err != nil {
+			hresp.Body.Close()
+			return nil, responseBodyError(hresp, err)
+		}
+	}
+	return hresp, nil
+}
+
+// successful determines whether a response with the given status code is
+// processed as a successful response.
+func (c *Client) successful(statusCode int) bool {
+	if 200 <= statusCode && statusCode < 300 {
+		return true
+	}
+	return c.AcceptStatus != nil && c.AcceptStatus(statusCode)
+}
+
+// marshalRequest creates the http.Request for a call to send. If the
+// returned pooledBody is not nil it must be released once the request
+// has completed.
+func (c *Client) marshalRequest(method, url, contentType string, v interface{}) (*http.Request, *pooledBody, error) {
+	contentType = c.contentType(contentType, v)
+	if _, raw := v.(RawBody); raw || !c.PoolRequestBodies || v == nil {
+		req, err := c.MarshalOptions.MarshalRequest(method, url, contentType, v)
+		return req, nil, err
+	}
+	contentType = c.MarshalOptions.valueContentType(contentType, v)
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := getBuffer()
+	if err := c.MarshalOptions.marshalTo(buf, charset, v); err != nil {
+		putBuffer(buf)
+		return nil, nil, err
+	}
+	compressed := c.MarshalOptions.compress(buf.Bytes())
+	if compressed {
+		zbuf := getBuffer()
+		err := gzipTo(zbuf, buf.Bytes())
+		putBuffer(buf)
+		if err != nil {
+			putBuffer(zbuf)
+			return nil, nil, err
+		}
+		buf = zbuf
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		putBuffer(buf)
+		return nil, nil, err
+	}
+	body := &pooledBody{buf: buf}
+	req.Body = body.newBody()
+	req.GetBody = body.getBody
+	req.ContentLength = int64(buf.Len())
+	req.Header.Set("Content-Type", contentType)
+	c.MarshalOptions.expectContinue(req)
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, body, nil
+}
+
+// onRequestBody calls OnRequestBody with a copy of the body of req,
+// obtained using GetBody so that the body that will be sent is not
+// consumed. This relies on GetBody returning a reader that is
+// independent of req.Body, which is true of every request created by
+// marshalRequest, a RawBody that could only be replayed by rewinding
+// req.Body has no GetBody.
+func (c *Client) onRequestBody(req *http.Request) error {
+	buf, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	c.OnRequestBody(buf)
+	return nil
+}
+
+// requestBody returns a copy of the body of req, obtained using GetBody.
+func requestBody(req *http.Request) ([]byte, error) {
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readAll(rc, req.ContentLength)
+}
+
+// DefaultAccept is the Accept header sent by a Client that has no
+// Accept or Decoders set. It prefers "application/json", accepts the
+// discouraged "text/json" with a lower quality, and accepts any other
+// type with the lowest quality, so that a server offering several
+// formats returns JSON, while one that cannot still responds with a
+// body that can be reported in a ResponseError.
+const DefaultAccept = "application/json, text/json;q=0.9, */*;q=0.1"
+
+// accept returns the value of the Accept header sent with requests, which
+// is Accept if set, otherwise DefaultAccept with the media types of any
+// Decoders listed after "application/json".
+func (c *Client) accept() string {
+	if c.Accept != "" {
+		return c.Accept
+	}
+	if len(c.Decoders) == 0 {
+		return DefaultAccept
 	}
-	return UnmarshalResponse(hresp, resp)
+	types := make([]string, 0, len(c.Decoders))
+	for mt := range c.Decoders {
+		types = append(types, mt)
+	}
+	sort.Strings(types)
+	return "application/json, " + strings.Join(types, ", ") + strings.TrimPrefix(DefaultAccept, "application/json")
+}
+
+// contentType determines the content type with which to send v when
+// the given content type was specified for the call.
+func (c *Client) contentType(contentType string, v interface{}) string {
+	if c.DefaultContentType == "" {
+		return contentType
+	}
+	if contentType == "" {
+		if ct, ok := v.(ContentTyper); ok {
+			contentType = ct.ContentType()
+		}
+	}
+	return MergeContentType(c.DefaultContentType, contentType)
+}
+
+// unmarshalResponse parses the JSON-encoded body of resp and stores the
+// result in the value pointed to by v.
+func (c *Client) unmarshalResponse(resp *http.Response, v interface{}) error {
+	buf, err := c.readResponse(resp)
+	if err != nil {
+		return err
+	}
+	return c.decode(resp, buf, v)
+}
+
+// decode parses the UTF-8 encoded body buf, read from resp, and stores
+// the result in the value pointed to by v.
+func (c *Client) decode(resp *http.Response, buf []byte, v interface{}) error {
+	if decoder := c.decoder(resp); decoder != nil {
+		return decoder(trimBOM(buf), v)
+	}
+	opts := UnmarshalOptions{
+		DisallowUnknownFields: c.DisallowUnknownFields,
+		UseNumber:             c.UseNumber,
+		AllowEmptyBody:        c.AllowEmptyBody,
+		UnmarshalFunc:         c.UnmarshalFunc,
+	}
+	return opts.unmarshalJSON(buf, v)
+}
+
+// decoder returns the function from Decoders for the media type of resp,
+// if there is one.
+func (c *Client) decoder(resp *http.Response) func([]byte, interface{}) error {
+	if len(c.Decoders) == 0 {
+		return nil
+	}
+	mt, _, err := ParseContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	return c.Decoders[mt]
+}
+
+// readResponse reads the whole UTF-8 encoded body of resp.
+func (c *Client) readResponse(resp *http.Response) ([]byte, error) {
+	r, err := c.responseReader(resp)
+	if err != nil {
+		return nil, err
+	}
+	hint := sizeHint(resp.Header, resp.ContentLength, c.MaxResponseBytes)
+	if c.Base64Body {
+		hint = -1
+	}
+	buf, err := readAll(r, hint)
+	if err != nil || !c.SniffCharset {
+		return buf, err
+	}
+	if charset, _ := contentCharset(resp.Header.Get("Content-Type")); charset != "" {
+		return buf, nil
+	}
+	if charset := sniffCharset(buf); charset != "" {
+		enc, _ := ianaindex.MIME.Encoding(charset)
+		return enc.NewDecoder().Bytes(buf)
+	}
+	return buf, nil
+}
+
+// responseReader returns a reader that produces the UTF-8 encoded body
+// of resp. Any Content-Encoding applied to the body, and any base64
+// encoding if Base64Body is set, is removed before the MaxResponseBytes
+// limit is applied.
+func (c *Client) responseReader(resp *http.Response) (io.Reader, error) {
+	r, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	if c.Base64Body {
+		r = base64.NewDecoder(base64.RawStdEncoding, &base64Reader{r: r})
+	}
+	r = limit(r, c.MaxResponseBytes, ErrResponseTooLarge)
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	return charsetReader(r, charset)
+}
+
+// A readCloser combines a Reader with the Closer of the underlying
+// stream.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// A cancelCloser closes an io.ReadCloser and then cancels the context
+// that the response was received with.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (r cancelCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// A onceCloser closes an io.ReadCloser and then, the first time it is
+// closed, calls f.
+type onceCloser struct {
+	io.ReadCloser
+	f    func()
+	once sync.Once
+}
+
+// Close implements io.Closer.
+func (r *onceCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.f)
+	return err
 }
 
 // A ResponseError is the error returned when the HTTP request returns a
-// valid response that is either not a successful response, or is not a
-// JSON content type.
+// valid response that is not a successful response.
 type ResponseError struct {
 	// Response contains the http.Response that caused the error. The
 	// Body field of this object will be nil and should be read from
@@ -104,39 +1062,348 @@ type ResponseError struct {
 	// Body contains the body of the http Response that caused the
 	// error.
 	Body []byte
+
+	// RequestBody contains the body of the request that caused the
+	// error, if it was captured because Client.CaptureRequestBody is
+	// set.
+	RequestBody []byte
+
+	// Err, if not nil, is an error decoded from the response, such as
+	// one created by Client.ErrorDecoder. It is returned by Unwrap.
+	Err error
+
+	// maxMessage is the maximum length of a message taken from Body, if
+	// it is zero defaultMaxErrorMessageBytes is used.
+	maxMessage int
+
+	// received is the time at which the response was received, it is
+	// zero if the ResponseError was not created by a Client.
+	received time.Time
+
+	// preserveSpace stops white space being trimmed from a message
+	// taken from Body.
+	preserveSpace bool
 }
 
-// Error implements error.
+// defaultMaxErrorMessageBytes is the maximum length of an error message
+// taken from a response body when no other limit is set.
+const defaultMaxErrorMessageBytes = 256
+
+// Error implements error. If the response has a text body then the body
+// is used as the error message, with surrounding white space removed and
+// truncated with an ellipsis if it is too long. If the response has an
+// RFC 7807 problem details body the message is formed from its title and
+// detail, truncated in the same way. Otherwise the message is the
+// response status.
 func (e *ResponseError) Error() string {
+	max := e.maxMessage
+	if max <= 0 {
+		max = defaultMaxErrorMessageBytes
+	}
+	if p, ok := e.Problem(); ok {
+		if msg := p.message(); msg != "" {
+			return truncate(msg, max)
+		}
+	}
 	// Attempt to use a text body as an error message.
-	mt, params, err := mime.ParseMediaType(e.Response.Header.Get("Content-Type"))
+	ct := e.Response.Header.Get("Content-Type")
+	mt, _, err := ParseContentType(ct)
 	if err == nil && strings.HasPrefix(mt, "text/") {
-		buf := e.Body
-		charset := params["charset"]
-		if charset != "" && !strings.EqualFold(charset, "utf-8") {
-			var enc encoding.Encoding
-			enc, err = ianaindex.MIME.Encoding(charset)
-			if err == nil && enc != nil {
-				buf, err = enc.NewDecoder().Bytes(buf)
-			}
+		var charset string
+		var buf []byte
+		charset, err = contentCharset(ct)
+		if err == nil {
+			buf, err = decodeCharset(e.Body, charset)
 		}
-		if err == nil && len(buf) > 0 && len(buf) < 256 {
-			return string(bytes.TrimSpace(buf))
+		if err == nil && !e.preserveSpace {
+			buf = bytes.TrimSpace(buf)
+		}
+		if err == nil && len(buf) > 0 {
+			return truncate(string(buf), max)
 		}
 	}
 	return e.Response.Status
 }
 
-// newResponseError creates a new ResponseError containing resp.
-func newResponseError(resp *http.Response) error {
-	body, err := io.ReadAll(resp.Body)
+// truncate shortens s to at most max bytes, without splitting a rune,
+// adding an ellipsis to mark that it has been truncated.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// BodyString returns the body of the response decoded from the
+// character set of its Content-Type into UTF-8, whatever the type or
+// size of the body, so that the whole body can be logged. Unlike Error
+// the body is not truncated or trimmed. If the body cannot be decoded it
+// is returned unchanged.
+func (e *ResponseError) BodyString() string {
+	charset, err := contentCharset(e.Response.Header.Get("Content-Type"))
+	if err != nil {
+		return string(e.Body)
+	}
+	buf, err := decodeCharset(e.Body, charset)
+	if err != nil {
+		return string(e.Body)
+	}
+	return string(buf)
+}
+
+// decodeCharset decodes buf from the given character set into UTF-8.
+func decodeCharset(buf []byte, charset string) ([]byte, error) {
+	charset = strings.TrimSpace(charset)
+	if charset == "" || strings.EqualFold(charset, "utf-8") {
+		return buf, nil
+	}
+	enc, err := ianaindex.MIME.Encoding(charset)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, errors.New("unsupported encoding")
+	}
+	return enc.NewDecoder().Bytes(buf)
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *ResponseError) StatusCode() int {
+	return e.Response.StatusCode
+}
+
+// Decode parses the JSON-encoded body of the response and stores the
+// result in the value pointed to by v. The body is decoded from the
+// character set specified in the response's Content-Type header before
+// parsing the JSON value. If the response does not have a JSON content
+// type, according to IsJSONContentType, the returned error will be of
+// type *ContentTypeError.
+func (e *ResponseError) Decode(v interface{}) error {
+	ct := e.Response.Header.Get("Content-Type")
+	if !IsJSONContentType(ct) {
+		return &ContentTypeError{Response: e.Response, Body: e.Body}
+	}
+	charset, err := contentCharset(ct)
 	if err != nil {
 		return err
 	}
+	return UnmarshalOptions{}.unmarshal(e.Body, charset, v)
+}
+
+// DelayUntil returns the time indicated by the Retry-After header of the
+// response, before which the request should not be retried. The delay
+// given by the header, as returned by RetryAfter, is added to the local
+// time at which the Client received the response, or the current time
+// if the ResponseError was not created by a Client, so that the result
+// can be compared with the local clock whatever the server's clock
+// says. If the response does not contain a valid Retry-After header
+// then DelayUntil returns false.
+func (e *ResponseError) DelayUntil() (time.Time, bool) {
+	d, ok := retryDelay(e.Response.Header)
+	if !ok {
+		return time.Time{}, false
+	}
+	received := e.received
+	if received.IsZero() {
+		received = time.Now()
+	}
+	return received.Add(d), true
+}
+
+// RetryAfter returns the delay indicated by the Retry-After header of
+// the response, which is typically sent with a 429 Too Many Requests or
+// 503 Service Unavailable status, so that a caller can implement its own
+// backoff. A Retry-After value containing a number of seconds is
+// returned as that duration. A value containing an HTTP date is
+// returned as the time between the response's Date header, or the
+// current time if the response has no valid Date header, and that date,
+// which is zero if the date has passed. Measuring from the Date header
+// avoids any difference between the server's clock and the local
+// clock. If the response does not contain a valid Retry-After header
+// then RetryAfter returns false.
+func (e *ResponseError) RetryAfter() (time.Duration, bool) {
+	return retryDelay(e.Response.Header)
+}
+
+// retryDelay returns the delay indicated by the Retry-After header in h,
+// in the same way as ResponseError.RetryAfter.
+func retryDelay(h http.Header) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseUint(v, 10, 31); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	now, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		now = time.Now()
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// maxContentTypeErrorBody is the maximum number of bytes of the body that
+// will be included in a ContentTypeError.
+const maxContentTypeErrorBody = 64 * 1024
+
+// A ContentTypeError is the error returned when the HTTP request returns
+// a successful response that does not have a JSON content type.
+type ContentTypeError struct {
+	// Response contains the http.Response that caused the error. The
+	// Body field of this object will be nil and should be read from
+	// the error's Body field.
+	Response *http.Response
+
+	// Body contains the start of the body of the http Response that
+	// caused the error. At most 64KiB of the body is included, less if
+	// the Client has a smaller MaxResponseBytes.
+	Body []byte
+}
+
+// Error implements error. If the Content-Type cannot be parsed, even
+// by the tolerant ParseContentType, the message describes the problem.
+func (e *ContentTypeError) Error() string {
+	ct := e.Response.Header.Get("Content-Type")
+	if ct != "" {
+		if _, _, err := ParseContentType(ct); err != nil {
+			return err.Error()
+		}
+	}
+	return fmt.Sprintf("unsupported Content-Type %q", ct)
+}
+
+// newContentTypeError creates a new ContentTypeError containing resp and
+// a bounded prefix of its body.
+func (c *Client) newContentTypeError(resp *http.Response) error {
+	n := int64(maxContentTypeErrorBody)
+	if c.MaxResponseBytes > 0 && c.MaxResponseBytes < n {
+		n = c.MaxResponseBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return responseBodyError(resp, err)
+	}
 	resp1 := *resp
 	resp1.Body = nil
-	return &ResponseError{
+	return &ContentTypeError{
 		Response: &resp1,
 		Body:     body,
 	}
 }
+
+// newResponseError creates a new ResponseError containing resp, which was
+// received in response to req. The request body is captured from req,
+// rather than from resp.Request, because before Go 1.24 the request of
+// a redirected call has no GetBody.
+func (c *Client) newResponseError(req *http.Request, resp *http.Response) error {
+	body, err := c.readErrorBody(resp)
+	if err != nil {
+		return responseBodyError(resp, err)
+	}
+	resp1 := *resp
+	resp1.Body = nil
+	rerr := &ResponseError{
+		Response:      &resp1,
+		Body:          body,
+		maxMessage:    c.MaxErrorMessageBytes,
+		received:      time.Now(),
+		preserveSpace: c.PreserveErrorMessageSpace,
+	}
+	if c.CaptureRequestBody {
+		rerr.RequestBody = c.captureRequestBody(req)
+	}
+	if c.ErrorDecoder != nil {
+		rerr.Err = c.ErrorDecoder(rerr)
+	}
+	return rerr
+}
+
+// captureRequestBody returns the body of req for a ResponseError, after
+// applying RedactRequestBody. A body that cannot be obtained is not
+// captured, rather than hiding the error from the response.
+func (c *Client) captureRequestBody(req *http.Request) []byte {
+	if req == nil || req.GetBody == nil {
+		return nil
+	}
+	body, err := requestBody(req)
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+	if c.RedactRequestBody != nil {
+		body = c.RedactRequestBody(body)
+	}
+	return body
+}
+
+// mapError creates the error for the unsuccessful response resp using
+// MapError. If MapError returns nil the body of resp is restored so that
+// the response can be processed as a successful one.
+func (c *Client) mapError(resp *http.Response) error {
+	body, err := c.readErrorBody(resp)
+	if err != nil {
+		return responseBodyError(resp, err)
+	}
+	if err := c.MapError(resp, body); err != nil {
+		return err
+	}
+	resp.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+		Closer: resp.Body,
+	}
+	return nil
+}
+
+// readErrorBody reads the body of the unsuccessful response resp.
+func (c *Client) readErrorBody(resp *http.Response) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if c.FailFastServerErrors && resp.StatusCode >= 500 {
+		// Read enough for the longest message even if every
+		// character needs four bytes.
+		max := c.MaxErrorMessageBytes
+		if max <= 0 {
+			max = defaultMaxErrorMessageBytes
+		}
+		r = io.LimitReader(r, 4*int64(max)+1)
+	}
+	if c.MaxResponseBytes > 0 {
+		r = io.LimitReader(r, c.MaxResponseBytes)
+	}
+	return io.ReadAll(r)
+}
+
+// requestError annotates err, which occurred while creating a request
+// with the given method and URL, with the method and URL. An error that
+// is already a *url.Error, such as a failure to parse the URL, already
+// contains them and is returned unchanged.
+func requestError(method, rawurl string, err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return err
+	}
+	u, perr := url.Parse(rawurl)
+	if perr != nil {
+		return err
+	}
+	return fmt.Errorf("%s %s: %w", method, u.Redacted(), err)
+}
+
+// responseBodyError annotates err, which occurred while reading or
+// decoding the body of resp, with the method and URL of the request that
+// produced resp and the status of resp.
+func responseBodyError(resp *http.Response, err error) error {
+	if resp.Request == nil {
+		return err
+	}
+	return fmt.Errorf("%s %s: %s: %w", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, err)
+}
@@ -0,0 +1,158 @@
+package httpjson
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrResponseTooLarge is the error returned when the body of an HTTP
+// response is larger than the configured maximum size.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrRequestTooLarge is the error returned when the body of an HTTP
+// request is larger than the configured maximum size.
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// defaultMaxDecompressedBytes is the limit applied to a decompressed
+// request body when UnmarshalOptions.MaxBodyBytes is zero.
+const defaultMaxDecompressedBytes = 10 << 20
+
+// minCompressSize is the smallest response body that
+// WriteResponseCompressed will compress. Compressing smaller bodies
+// rarely saves enough to be worthwhile.
+const minCompressSize = 1024
+
+// WriteResponseCompressed writes the JSON encoding of v as the body of an
+// HTTP response in the same way as WriteResponse, compressing the body
+// when the client can accept it. If the Accept-Encoding header of req
+// allows gzip and the encoded body is at least 1KiB then the body is gzip
+// compressed and the Content-Encoding header is set, the Content-Length
+// header always contains the length of the body as sent. A
+// "Vary: Accept-Encoding" header is added to every response with a body.
+func WriteResponseCompressed(w http.ResponseWriter, req *http.Request, statusCode int, contentType string, v interface{}) error {
+	if v == nil {
+		return WriteResponse(w, statusCode, contentType, v)
+	}
+	contentType = MarshalOptions{}.valueContentType(contentType, v)
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		return err
+	}
+	body, err := MarshalOptions{}.marshal(charset, v)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) >= minCompressSize && acceptsEncoding(req.Header.Get("Accept-Encoding"), "gzip") {
+		var buf bytes.Buffer
+		if err := gzipTo(&buf, body); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	return MarshalOptions{}.writeBody(w, statusCode, contentType, body)
+}
+
+// gzipTo writes the gzip compressed form of body to dst.
+func gzipTo(dst *bytes.Buffer, body []byte) error {
+	zw := gzip.NewWriter(dst)
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// decompress wraps r in a reader that decodes the given Content-Encoding.
+// An empty or "identity" encoding returns r unchanged. An empty gzip or
+// deflate body is also returned unchanged, rather than failing, as some
+// servers label empty bodies with an encoding they haven't applied.
+// Errors reading a malformed body are reported as errors decompressing
+// the body, wrapping the underlying error.
+func decompress(r io.Reader, contentEncoding string) (io.Reader, error) {
+	coding := strings.ToLower(strings.TrimSpace(contentEncoding))
+	switch coding {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip", "deflate":
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
+		return br, nil
+	}
+	var zr io.Reader
+	var err error
+	if coding == "deflate" {
+		zr, err = zlib.NewReader(br)
+	} else {
+		zr, err = gzip.NewReader(br)
+	}
+	if err != nil {
+		return nil, decompressError(coding, err)
+	}
+	return decompressReader{r: zr, coding: coding}, nil
+}
+
+// A decompressReader reads a decompressed body, reporting any error as
+// an error decompressing the body.
+type decompressReader struct {
+	r      io.Reader
+	coding string
+}
+
+// Read implements io.Reader.
+func (r decompressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = decompressError(r.coding, err)
+	}
+	return n, err
+}
+
+// decompressError returns an error reporting that a body with the given
+// Content-Encoding could not be decompressed because of err.
+func decompressError(coding string, err error) error {
+	return fmt.Errorf("decompressing %s body: %w", coding, err)
+}
+
+// limit returns a reader that reads from r. If max is greater than zero
+// and r contains more than max bytes then reading beyond max bytes will
+// return tooLarge.
+func limit(r io.Reader, max int64, tooLarge error) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitReader{r: r, n: max, err: tooLarge}
+}
+
+// A limitReader reads from r, returning err if more than n bytes remain.
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+// Read implements io.Reader.
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n - 1, l.err
+	}
+	return n, err
+}
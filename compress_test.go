@@ -0,0 +1,489 @@
+package httpjson_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestClientDoGzipResponse(t *testing.T) {
+	srv := httptest.NewServer(gzipHandler(`{"s":"test message ☺"}`))
+	defer srv.Close()
+	cl := httpjson.Client{
+		HTTPClient: noDecompressionClient(),
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "test message ☺")
+}
+
+func TestClientDoGzipResponseTooLarge(t *testing.T) {
+	body := `{"s":"` + strings.Repeat("a", 1<<20) + `"}`
+	srv := httptest.NewServer(gzipHandler(body))
+	defer srv.Close()
+	cl := httpjson.Client{
+		HTTPClient:       noDecompressionClient(),
+		MaxResponseBytes: 1024,
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrResponseTooLarge)
+}
+
+func TestClientDoResponseTooLarge(t *testing.T) {
+	srv := httptest.NewServer(valueHandler{v: testValue{S: strings.Repeat("a", 2048)}})
+	defer srv.Close()
+	cl := httpjson.Client{
+		MaxResponseBytes: 1024,
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrResponseTooLarge)
+}
+
+var unmarshalRequestCompressedTests = []struct {
+	name            string
+	contentEncoding string
+	body            []byte
+	maxBodyBytes    int64
+	expectError     string
+	expectValue     testValue
+}{{
+	name:            "gzip",
+	contentEncoding: "gzip",
+	body:            gzipBytes(`{"s":"test message ☺"}`),
+	expectValue:     testValue{S: "test message ☺"},
+}, {
+	name:            "deflate",
+	contentEncoding: "deflate",
+	body:            zlibBytes(`{"s":"test message ☺"}`),
+	expectValue:     testValue{S: "test message ☺"},
+}, {
+	name:            "identity",
+	contentEncoding: "identity",
+	body:            []byte(`{"s":"test message ☺"}`),
+	expectValue:     testValue{S: "test message ☺"},
+}, {
+	name:            "gzip_within_limit",
+	contentEncoding: "gzip",
+	body:            gzipBytes(`{"s":"test message ☺"}`),
+	maxBodyBytes:    1024,
+	expectValue:     testValue{S: "test message ☺"},
+}, {
+	name:            "gzip_bomb",
+	contentEncoding: "gzip",
+	body:            gzipBytes(`{"s":"` + strings.Repeat("a", 1<<20) + `"}`),
+	maxBodyBytes:    1024,
+	expectError:     `request body too large`,
+}, {
+	name:         "uncompressed_too_large",
+	body:         []byte(`{"s":"` + strings.Repeat("a", 2048) + `"}`),
+	maxBodyBytes: 1024,
+	expectError:  `request body too large`,
+}, {
+	name:            "unknown_encoding",
+	contentEncoding: "br",
+	body:            []byte(`{"s":"test message ☺"}`),
+	expectError:     `unsupported Content-Encoding "br"`,
+}, {
+	name:            "not_gzip",
+	contentEncoding: "gzip",
+	body:            []byte(`{"s":"test message ☺"}`),
+	expectError:     `decompressing gzip body: gzip: invalid header`,
+}, {
+	name:            "truncated_gzip",
+	contentEncoding: "gzip",
+	body:            gzipBytes(`{"s":"test message ☺"}`)[:20],
+	expectError:     `decompressing gzip body: unexpected EOF`,
+}, {
+	name:            "corrupt_deflate",
+	contentEncoding: "deflate",
+	body:            append(zlibBytes(`{"s":"test message ☺"}`)[:2], 0xff, 0xff, 0xff),
+	expectError:     `decompressing deflate body: flate: corrupt input before offset 1`,
+}}
+
+func TestUnmarshalRequestCompressed(t *testing.T) {
+	for _, test := range unmarshalRequestCompressedTests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://test.example.com", bytes.NewReader(test.body))
+			qt.Assert(t, err, qt.IsNil)
+			req.Header.Set("Content-Type", "application/json;charset=utf-8")
+			if test.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", test.contentEncoding)
+			}
+			var v testValue
+			opts := httpjson.UnmarshalOptions{MaxBodyBytes: test.maxBodyBytes}
+			err = opts.UnmarshalRequest(req, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				if test.expectError == `request body too large` {
+					qt.Check(t, err, qt.ErrorIs, httpjson.ErrRequestTooLarge)
+				}
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v, qt.Equals, test.expectValue)
+		})
+	}
+}
+
+var unmarshalResponseCompressedTests = []struct {
+	name            string
+	contentEncoding string
+	body            []byte
+	expectError     string
+}{{
+	name:            "gzip",
+	contentEncoding: "gzip",
+	body:            gzipBytes(`{"s":"test message ☺"}`),
+}, {
+	name:            "x-gzip",
+	contentEncoding: "x-gzip",
+	body:            gzipBytes(`{"s":"test message ☺"}`),
+}, {
+	name:            "deflate",
+	contentEncoding: "Deflate",
+	body:            zlibBytes(`{"s":"test message ☺"}`),
+}, {
+	name:            "identity",
+	contentEncoding: "identity",
+	body:            []byte(`{"s":"test message ☺"}`),
+}, {
+	name:            "empty_gzip",
+	contentEncoding: "gzip",
+	body:            []byte{},
+	expectError:     `empty body`,
+}, {
+	name:            "empty_deflate",
+	contentEncoding: "deflate",
+	body:            []byte{},
+	expectError:     `empty body`,
+}, {
+	name:            "unknown",
+	contentEncoding: "br",
+	body:            []byte(`{"s":"test message ☺"}`),
+	expectError:     `unsupported Content-Encoding "br"`,
+}}
+
+func TestUnmarshalResponseCompressed(t *testing.T) {
+	for _, test := range unmarshalResponseCompressedTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type":     {"application/json;charset=utf-8"},
+					"Content-Encoding": {test.contentEncoding},
+				},
+				Body:          io.NopCloser(bytes.NewReader(test.body)),
+				ContentLength: int64(len(test.body)),
+			}
+			var v testValue
+			err := httpjson.UnmarshalResponse(resp, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v.S, qt.Equals, "test message ☺")
+		})
+	}
+}
+
+func TestClientDeleteEmptyGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cl := httpjson.Client{HTTPClient: noDecompressionClient()}
+	resp := testValue{S: "unchanged"}
+	err := cl.Delete(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "unchanged")
+}
+
+func TestUnmarshalRequestGzipBomb(t *testing.T) {
+	body := gzipBytes(`{"s":"` + strings.Repeat("a", 11<<20) + `"}`)
+	req, err := http.NewRequest("POST", "https://test.example.com", bytes.NewReader(body))
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	var v testValue
+	err = httpjson.UnmarshalRequest(req, &v)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrRequestTooLarge)
+
+	// An uncompressed body of the same size is not limited.
+	req, err = http.NewRequest("POST", "https://test.example.com", strings.NewReader(`{"s":"`+strings.Repeat("a", 11<<20)+`"}`))
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	err = httpjson.UnmarshalRequest(req, &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, len(v.S), qt.Equals, 11<<20)
+}
+
+func TestUnmarshalRequestGzipServer(t *testing.T) {
+	srv := httptest.NewServer(echoHandler)
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL, bytes.NewReader(gzipBytes(`{"s":"test message ☺"}`)))
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	var v testValue
+	err = httpjson.UnmarshalResponse(resp, &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v.S, qt.Equals, "test message ☺")
+}
+
+func TestMarshalRequestGzip(t *testing.T) {
+	v := testValue{S: strings.Repeat("☺", 100)}
+	tests := []struct {
+		name         string
+		minBytes     int
+		expectGzip   bool
+		expectLength int64
+	}{
+		{"disabled", 0, false, 608},
+		{"below_threshold", 609, false, 608},
+		{"at_threshold", 608, true, -1},
+		{"above_threshold", 10, true, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := httpjson.MarshalOptions{GzipMinBytes: test.minBytes}
+			req, err := opts.MarshalRequest("POST", "https://test.example.com", "application/json;charset=us-ascii", v)
+			qt.Assert(t, err, qt.IsNil)
+			buf, err := io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, req.ContentLength, qt.Equals, int64(len(buf)))
+			if !test.expectGzip {
+				qt.Check(t, req.Header.Get("Content-Encoding"), qt.Equals, "")
+				qt.Check(t, req.ContentLength, qt.Equals, test.expectLength)
+				return
+			}
+			qt.Check(t, req.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+			qt.Check(t, req.ContentLength < 608, qt.IsTrue)
+
+			// GetBody produces the same compressed body.
+			body, err := req.GetBody()
+			qt.Assert(t, err, qt.IsNil)
+			buf2, err := io.ReadAll(body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, buf2, qt.DeepEquals, buf)
+
+			zr, err := gzip.NewReader(bytes.NewReader(buf))
+			qt.Assert(t, err, qt.IsNil)
+			plain, err := io.ReadAll(zr)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(plain), qt.Equals, `{"s":"`+strings.Repeat(`\u263a`, 100)+`"}`)
+		})
+	}
+}
+
+func TestClientDoGzipRequest(t *testing.T) {
+	var encodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		encodings = append(encodings, req.Header.Get("Content-Encoding"))
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		echoHandler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	for _, pool := range []bool{false, true} {
+		encodings = nil
+		cl := httpjson.Client{
+			MarshalOptions:    httpjson.MarshalOptions{GzipMinBytes: 100},
+			PoolRequestBodies: pool,
+		}
+		var resp testValue
+		err := cl.Do(context.Background(), "POST", srv.URL+"/redirect", "", testValue{S: strings.Repeat("☺", 100)}, &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, strings.Repeat("☺", 100))
+		qt.Check(t, encodings, qt.DeepEquals, []string{"gzip", "gzip"})
+
+		encodings = nil
+		err = cl.Do(context.Background(), "POST", srv.URL, "", testValue{S: "☺"}, &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, "☺")
+		qt.Check(t, encodings, qt.DeepEquals, []string{""})
+	}
+}
+
+var writeResponseCompressedTests = []struct {
+	name           string
+	acceptEncoding string
+	v              interface{}
+	expectGzip     bool
+}{{
+	name:           "gzip_accepted",
+	acceptEncoding: "gzip, deflate, br",
+	v:              testValue{S: strings.Repeat("☺", 1000)},
+	expectGzip:     true,
+}, {
+	name:           "wildcard_accepted",
+	acceptEncoding: "*",
+	v:              testValue{S: strings.Repeat("☺", 1000)},
+	expectGzip:     true,
+}, {
+	name:       "not_accepted",
+	v:          testValue{S: strings.Repeat("☺", 1000)},
+	expectGzip: false,
+}, {
+	name:           "gzip_refused",
+	acceptEncoding: "br, gzip;q=0, *;q=0.5",
+	v:              testValue{S: strings.Repeat("☺", 1000)},
+	expectGzip:     false,
+}, {
+	name:           "small_body",
+	acceptEncoding: "gzip",
+	v:              testValue{S: "☺"},
+	expectGzip:     false,
+}}
+
+func TestWriteResponseCompressed(t *testing.T) {
+	for _, test := range writeResponseCompressedTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			err := httpjson.WriteResponseCompressed(rr, req, http.StatusOK, "", test.v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			qt.Check(t, resp.Header.Get("Vary"), qt.Equals, "Accept-Encoding")
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(body))
+			if test.expectGzip {
+				qt.Check(t, resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				qt.Assert(t, err, qt.IsNil)
+				body, err = io.ReadAll(zr)
+				qt.Assert(t, err, qt.IsNil)
+			} else {
+				qt.Check(t, resp.Header.Get("Content-Encoding"), qt.Equals, "")
+			}
+			qt.Check(t, body, qt.JSONEquals, test.v)
+		})
+	}
+}
+
+func TestWriteResponseCompressedNoContent(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	err := httpjson.WriteResponseCompressed(rr, req, http.StatusNoContent, "", nil)
+	qt.Assert(t, err, qt.IsNil)
+	resp := rr.Result()
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusNoContent)
+	qt.Check(t, resp.Header.Get("Content-Encoding"), qt.Equals, "")
+}
+
+func gzipBytes(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zlibBytes(s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+// gzipHandler returns a handler that responds with the gzip compressed
+// JSON document in body.
+func gzipHandler(body string) http.Handler {
+	buf := gzipBytes(body)
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf)
+	})
+}
+
+// noDecompressionClient returns an http.Client that doesn't transparently
+// decompress response bodies.
+func noDecompressionClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DisableCompression: true,
+		},
+	}
+}
+
+// A junkReader produces n bytes of data that is not valid JSON,
+// recording how much has been read.
+type junkReader struct {
+	n    int64
+	read int64
+}
+
+func (r *junkReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.n -= int64(len(p))
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestUnmarshalResponseTrailingData(t *testing.T) {
+	for _, coding := range []string{"gzip", "deflate"} {
+		t.Run(coding, func(t *testing.T) {
+			body := zlibBytes(`{"s":"a"}`)
+			if coding == "gzip" {
+				body = gzipBytes(`{"s":"a"}`)
+			}
+			junk := &junkReader{n: 50 << 20}
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type":     {"application/json"},
+					"Content-Encoding": {coding},
+				},
+				Body: io.NopCloser(io.MultiReader(bytes.NewReader(body), junk)),
+			}
+			var v testValue
+			err := httpjson.UnmarshalOptions{MaxBodyBytes: 1024}.UnmarshalResponse(resp, &v)
+			qt.Check(t, err, qt.Not(qt.IsNil))
+			qt.Check(t, v, qt.Equals, testValue{})
+			qt.Check(t, junk.read < 1<<20, qt.IsTrue, qt.Commentf("read %d bytes", junk.read))
+			if coding == "deflate" {
+				qt.Check(t, err, qt.ErrorIs, httpjson.ErrTrailingData)
+			}
+		})
+	}
+}
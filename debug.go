@@ -0,0 +1,70 @@
+package httpjson
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"os"
+)
+
+// defaultDebugRedactHeaders contains the headers that are redacted in
+// Debug output if Client.DebugRedactHeaders is nil.
+var defaultDebugRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// dumpRequest writes a dump of req to the debug writer. The body of req
+// is replaced with a copy of the body that was read.
+func (c *Client) dumpRequest(req *http.Request) error {
+	r := *req
+	r.Header = c.redactHeaders(req.Header)
+	dump, err := httputil.DumpRequestOut(&r, true)
+	req.Body = r.Body
+	if err != nil {
+		return err
+	}
+	c.writeDebug(dump)
+	return nil
+}
+
+// dumpResponse writes a dump of resp to the debug writer. The body of
+// resp is replaced with a copy of the body that was read.
+func (c *Client) dumpResponse(resp *http.Response) error {
+	r := *resp
+	r.Header = c.redactHeaders(resp.Header)
+	dump, err := httputil.DumpResponse(&r, true)
+	resp.Body = r.Body
+	if err != nil {
+		return err
+	}
+	c.writeDebug(dump)
+	return nil
+}
+
+// redactHeaders returns a copy of h with the values of the configured
+// headers redacted.
+func (c *Client) redactHeaders(h http.Header) http.Header {
+	redact := c.DebugRedactHeaders
+	if redact == nil {
+		redact = defaultDebugRedactHeaders
+	}
+	h = h.Clone()
+	for _, k := range redact {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := h[k]; ok {
+			h[k] = []string{"[REDACTED]"}
+		}
+	}
+	return h
+}
+
+// writeDebug writes a single dump to the debug writer.
+func (c *Client) writeDebug(dump []byte) {
+	w := c.DebugWriter
+	if w == nil {
+		w = os.Stderr
+	}
+	w.Write(append(dump, '\n'))
+}
@@ -0,0 +1,88 @@
+package httpjson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestClientDebug(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "response-secret"})
+		w.Header().Set("X-Request-Id", "1234")
+		echoHandler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	for _, pool := range []bool{false, true} {
+		var buf bytes.Buffer
+		client := &httpjson.Client{
+			PoolRequestBodies: pool,
+			Debug:             true,
+			DebugWriter:       &buf,
+		}
+		ctx := httpjson.ContextWithHeader(context.Background(), http.Header{
+			"Authorization": {"Bearer request-secret"},
+			"X-Trace":       {"abc"},
+		})
+		var resp testValue
+		err := client.Do(ctx, "POST", srv.URL+"/path", "", testValue{S: "test message ☺"}, &resp)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, resp.S, qt.Equals, "test message ☺")
+
+		dump := buf.String()
+		qt.Check(t, dump, qt.Contains, "POST /path HTTP/1.1\r\n")
+		qt.Check(t, dump, qt.Contains, "X-Trace: abc\r\n")
+		qt.Check(t, dump, qt.Contains, "Authorization: [REDACTED]\r\n")
+		qt.Check(t, dump, qt.Contains, "\r\n\r\n"+`{"s":"test message ☺"}`)
+		qt.Check(t, dump, qt.Contains, "HTTP/1.1 200 OK\r\n")
+		qt.Check(t, dump, qt.Contains, "X-Request-Id: 1234\r\n")
+		qt.Check(t, dump, qt.Contains, "Set-Cookie: [REDACTED]\r\n")
+		qt.Check(t, strings.Count(dump, `{"s":"test message ☺"}`), qt.Equals, 2)
+		qt.Check(t, dump, qt.Not(qt.Contains), "secret")
+	}
+}
+
+func TestClientDebugRedactHeaders(t *testing.T) {
+	srv := httptest.NewServer(echoHandler)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	client := &httpjson.Client{
+		Debug:              true,
+		DebugWriter:        &buf,
+		DebugRedactHeaders: []string{"x-api-key"},
+	}
+	ctx := httpjson.ContextWithHeader(context.Background(), http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Api-Key":     {"key"},
+	})
+	var resp testValue
+	err := client.Do(ctx, "POST", srv.URL, "", testValue{S: "test"}, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "test")
+	qt.Check(t, buf.String(), qt.Contains, "Authorization: Bearer token\r\n")
+	qt.Check(t, buf.String(), qt.Contains, "X-Api-Key: [REDACTED]\r\n")
+}
+
+func TestClientDebugResponseError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	client := &httpjson.Client{
+		Debug:       true,
+		DebugWriter: &buf,
+	}
+	err := client.Get(context.Background(), srv.URL, new(testValue))
+	qt.Check(t, err, qt.ErrorMatches, `404 page not found`)
+	qt.Check(t, buf.String(), qt.Contains, "HTTP/1.1 404 Not Found\r\n")
+	qt.Check(t, buf.String(), qt.Contains, "404 page not found\n")
+}
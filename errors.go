@@ -0,0 +1,220 @@
+package httpjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// A StatusError is an error that represents an HTTP status code. A
+// *ResponseError matches, using errors.Is, the StatusError with the
+// same status code as its response, so that errors can be checked
+// with, for example:
+//
+//	errors.Is(err, httpjson.ErrNotFound)
+//
+// Any status code can be matched by converting it to a StatusError.
+type StatusError int
+
+// Error implements error.
+func (e StatusError) Error() string {
+	if text := http.StatusText(int(e)); text != "" {
+		return fmt.Sprintf("%d %s", int(e), text)
+	}
+	return fmt.Sprintf("status %d", int(e))
+}
+
+// Errors matching a *ResponseError with the corresponding status code.
+var (
+	ErrNotModified          error = StatusError(http.StatusNotModified)
+	ErrBadRequest           error = StatusError(http.StatusBadRequest)
+	ErrUnauthorized         error = StatusError(http.StatusUnauthorized)
+	ErrForbidden            error = StatusError(http.StatusForbidden)
+	ErrNotFound             error = StatusError(http.StatusNotFound)
+	ErrMethodNotAllowed     error = StatusError(http.StatusMethodNotAllowed)
+	ErrNotAcceptable        error = StatusError(http.StatusNotAcceptable)
+	ErrConflict             error = StatusError(http.StatusConflict)
+	ErrGone                 error = StatusError(http.StatusGone)
+	ErrPreconditionFailed   error = StatusError(http.StatusPreconditionFailed)
+	ErrUnsupportedMediaType error = StatusError(http.StatusUnsupportedMediaType)
+	ErrUnprocessableEntity  error = StatusError(http.StatusUnprocessableEntity)
+	ErrTooManyRequests      error = StatusError(http.StatusTooManyRequests)
+	ErrInternalServer       error = StatusError(http.StatusInternalServerError)
+	ErrNotImplemented       error = StatusError(http.StatusNotImplemented)
+	ErrBadGateway           error = StatusError(http.StatusBadGateway)
+	ErrServiceUnavailable   error = StatusError(http.StatusServiceUnavailable)
+	ErrGatewayTimeout       error = StatusError(http.StatusGatewayTimeout)
+)
+
+// Is reports whether target is the StatusError for the status code of
+// the response. It is used by errors.Is.
+func (e *ResponseError) Is(target error) bool {
+	s, ok := target.(StatusError)
+	return ok && int(s) == e.Response.StatusCode
+}
+
+// Unwrap returns the error decoded from the response, if any, so that
+// it can be found by errors.Is and errors.As.
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// A Problem is a problem details object, as defined by RFC 7807, which
+// describes an error in a response with the "application/problem+json"
+// content type. Extension members are not included, they can be read
+// using ResponseError.Decode.
+type Problem struct {
+	// Type is a URI reference identifying the problem type.
+	Type string `json:"type,omitempty"`
+
+	// Title is a short, human-readable summary of the problem type.
+	Title string `json:"title,omitempty"`
+
+	// Status is the HTTP status code generated by the origin server
+	// for this occurrence of the problem.
+	Status int `json:"status,omitempty"`
+
+	// Detail is a human-readable explanation specific to this
+	// occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI reference identifying the specific occurrence
+	// of the problem.
+	Instance string `json:"instance,omitempty"`
+}
+
+// Problem returns the problem details object in the body of the
+// response, if the response has the "application/problem+json" content
+// type. If the response does not contain a valid problem details object
+// then Problem returns false.
+func (e *ResponseError) Problem() (*Problem, bool) {
+	mt, _, err := ParseContentType(e.Response.Header.Get("Content-Type"))
+	if err != nil || mt != "application/problem+json" {
+		return nil, false
+	}
+	var p Problem
+	if err := e.Decode(&p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// message returns an error message describing the problem, or an empty
+// string if the problem has neither a title nor detail.
+func (p *Problem) message() string {
+	title := strings.TrimSpace(p.Title)
+	detail := strings.TrimSpace(p.Detail)
+	switch {
+	case title == "":
+		return detail
+	case detail == "":
+		return title
+	}
+	return title + ": " + detail
+}
+
+// A SchemeError is the error returned by a Client, before any request
+// is sent, when the URL of a call does not have the scheme "http" or
+// "https". Such a request would otherwise fail in the transport with a
+// less helpful message.
+type SchemeError struct {
+	// Scheme is the scheme of the URL, it is empty if the URL does not
+	// have one.
+	Scheme string
+}
+
+// Error implements error.
+func (e *SchemeError) Error() string {
+	if e.Scheme == "" {
+		return "URL has no scheme, it must be http or https"
+	}
+	return fmt.Sprintf("unsupported URL scheme %q, it must be http or https", e.Scheme)
+}
+
+// checkScheme returns a *SchemeError if rawurl is not an http or https
+// URL. A URL that cannot be parsed is left for http.NewRequest to
+// report.
+func checkScheme(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return nil
+	}
+	return &SchemeError{Scheme: u.Scheme}
+}
+
+// A DecodeError is the error returned when a JSON document cannot be
+// decoded because of a *json.SyntaxError or *json.UnmarshalTypeError.
+// It adds the position of the error, along with the surrounding part of
+// the document, to the error message, so that the problem can be found
+// in a large document. The original error can be recovered using
+// errors.As.
+type DecodeError struct {
+	// Err is the *json.SyntaxError or *json.UnmarshalTypeError
+	// reported by the decoder.
+	Err error
+
+	// Offset is the offset, in bytes, of the error in the UTF-8
+	// encoded document.
+	Offset int64
+
+	// Context is the part of the document surrounding the error.
+	Context string
+}
+
+// decodeErrorContext is the maximum number of bytes either side of the
+// error included in DecodeError.Context.
+const decodeErrorContext = 20
+
+// newDecodeError returns err as a *DecodeError if it is an error that
+// reports a position in the UTF-8 encoded document buf, otherwise err is
+// returned unchanged.
+func newDecodeError(buf []byte, err error) error {
+	var offset int64
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		offset = err.Offset
+	case *json.UnmarshalTypeError:
+		offset = err.Offset
+	default:
+		return err
+	}
+	if offset < 0 || offset > int64(len(buf)) {
+		return err
+	}
+	start, end := int(offset)-decodeErrorContext, int(offset)+decodeErrorContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(buf) {
+		end = len(buf)
+	}
+	// Don't split a character at either end of the context.
+	for start > 0 && !utf8.RuneStart(buf[start]) {
+		start++
+	}
+	for end < len(buf) && !utf8.RuneStart(buf[end]) {
+		end--
+	}
+	return &DecodeError{
+		Err:     err,
+		Offset:  offset,
+		Context: string(buf[start:end]),
+	}
+}
+
+// Error implements error.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v (offset %d near %q)", e.Err, e.Offset, e.Context)
+}
+
+// Unwrap returns the underlying decoder error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,207 @@
+package httpjson_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+var statusErrorTests = []struct {
+	statusCode int
+	expect     error
+}{
+	{http.StatusBadRequest, httpjson.ErrBadRequest},
+	{http.StatusUnauthorized, httpjson.ErrUnauthorized},
+	{http.StatusForbidden, httpjson.ErrForbidden},
+	{http.StatusNotFound, httpjson.ErrNotFound},
+	{http.StatusMethodNotAllowed, httpjson.ErrMethodNotAllowed},
+	{http.StatusNotAcceptable, httpjson.ErrNotAcceptable},
+	{http.StatusConflict, httpjson.ErrConflict},
+	{http.StatusGone, httpjson.ErrGone},
+	{http.StatusPreconditionFailed, httpjson.ErrPreconditionFailed},
+	{http.StatusUnsupportedMediaType, httpjson.ErrUnsupportedMediaType},
+	{http.StatusUnprocessableEntity, httpjson.ErrUnprocessableEntity},
+	{http.StatusTooManyRequests, httpjson.ErrTooManyRequests},
+	{http.StatusInternalServerError, httpjson.ErrInternalServer},
+	{http.StatusNotImplemented, httpjson.ErrNotImplemented},
+	{http.StatusBadGateway, httpjson.ErrBadGateway},
+	{http.StatusServiceUnavailable, httpjson.ErrServiceUnavailable},
+	{http.StatusGatewayTimeout, httpjson.ErrGatewayTimeout},
+	{http.StatusTeapot, httpjson.StatusError(http.StatusTeapot)},
+}
+
+func TestResponseErrorIs(t *testing.T) {
+	for _, test := range statusErrorTests {
+		t.Run(http.StatusText(test.statusCode), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(test.statusCode)
+			}))
+			defer srv.Close()
+
+			err := httpjson.Get(context.Background(), srv.URL, new(testValue))
+			qt.Check(t, err, qt.ErrorIs, test.expect)
+			for _, other := range statusErrorTests {
+				if other.statusCode != test.statusCode {
+					qt.Check(t, errors.Is(err, other.expect), qt.IsFalse)
+				}
+			}
+			var rerr *httpjson.ResponseError
+			qt.Check(t, errors.As(err, &rerr), qt.IsTrue)
+		})
+	}
+}
+
+func TestStatusErrorMessage(t *testing.T) {
+	qt.Check(t, httpjson.ErrNotFound, qt.ErrorMatches, `404 Not Found`)
+	qt.Check(t, httpjson.StatusError(599), qt.ErrorMatches, `status 599`)
+}
+
+var problemTests = []struct {
+	name          string
+	contentType   string
+	body          string
+	expectProblem *httpjson.Problem
+	expectMessage string
+}{{
+	name:        "title_and_detail",
+	contentType: "application/problem+json",
+	body:        `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","balance":30}`,
+	expectProblem: &httpjson.Problem{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   403,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+	},
+	expectMessage: `You do not have enough credit.: Your current balance is 30, but that costs 50.`,
+}, {
+	name:        "title_only",
+	contentType: "application/problem+json;charset=utf-8",
+	body:        `{"title":"Not allowed"}`,
+	expectProblem: &httpjson.Problem{
+		Title: "Not allowed",
+	},
+	expectMessage: `Not allowed`,
+}, {
+	name:        "detail_only",
+	contentType: "application/problem+json;charset=iso-8859-1",
+	body:        "{\"detail\":\"Costs \xa350\"}",
+	expectProblem: &httpjson.Problem{
+		Detail: "Costs £50",
+	},
+	expectMessage: `Costs £50`,
+}, {
+	name:          "no_message",
+	contentType:   "application/problem+json",
+	body:          `{"type":"about:blank","status":403}`,
+	expectProblem: &httpjson.Problem{Type: "about:blank", Status: 403},
+	expectMessage: `403 Forbidden`,
+}, {
+	name:          "invalid",
+	contentType:   "application/problem+json",
+	body:          `{"title":`,
+	expectMessage: `403 Forbidden`,
+}, {
+	name:          "not_problem",
+	contentType:   "application/json",
+	body:          `{"title":"Not allowed"}`,
+	expectMessage: `403 Forbidden`,
+}}
+
+func TestResponseErrorProblem(t *testing.T) {
+	for _, test := range problemTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			err := httpjson.Get(context.Background(), srv.URL, new(testValue))
+			qt.Check(t, err, qt.ErrorMatches, regexp.QuoteMeta(test.expectMessage))
+			var rerr *httpjson.ResponseError
+			qt.Assert(t, errors.As(err, &rerr), qt.IsTrue)
+			p, ok := rerr.Problem()
+			if test.expectProblem == nil {
+				qt.Check(t, ok, qt.IsFalse)
+				return
+			}
+			qt.Assert(t, ok, qt.IsTrue)
+			qt.Check(t, p, qt.DeepEquals, test.expectProblem)
+		})
+	}
+}
+
+func TestResponseErrorProblemTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"title":"Invalid request","detail":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer srv.Close()
+
+	cl := httpjson.Client{MaxErrorMessageBytes: 20}
+	err := cl.Get(context.Background(), srv.URL, new(testValue))
+	qt.Check(t, err, qt.ErrorMatches, `Invalid request: xxx…`)
+}
+
+var decodeErrorTests = []struct {
+	name          string
+	body          string
+	expectOffset  int64
+	expectContext string
+	expectSyntax  bool
+}{{
+	name:          "syntax",
+	body:          `{"items":[` + strings.Repeat(`1,`, 100) + `x` + strings.Repeat(`,1`, 100) + `]}`,
+	expectOffset:  211,
+	expectContext: `,1,1,1,1,1,1,1,1,1,x,1,1,1,1,1,1,1,1,1,1`,
+	expectSyntax:  true,
+}, {
+	name:          "end_of_input",
+	body:          `{"items":[1,2`,
+	expectOffset:  13,
+	expectContext: `{"items":[1,2`,
+	expectSyntax:  true,
+}, {
+	name:          "type",
+	body:          `{"x": {"s": 2}, "s": "☺☺☺☺☺☺☺☺"}`,
+	expectOffset:  13,
+	expectContext: `{"x": {"s": 2}, "s": "☺☺☺`,
+}}
+
+func TestDecodeError(t *testing.T) {
+	for _, test := range decodeErrorTests {
+		t.Run(test.name, func(t *testing.T) {
+			var v struct {
+				Items []int
+				X     testValue
+			}
+			err := httpjson.Unmarshal([]byte(test.body), "application/json", &v)
+			var derr *httpjson.DecodeError
+			qt.Assert(t, errors.As(err, &derr), qt.IsTrue)
+			qt.Check(t, derr.Offset, qt.Equals, test.expectOffset)
+			qt.Check(t, derr.Context, qt.Equals, test.expectContext)
+			qt.Check(t, err, qt.ErrorMatches, `.* \(offset [0-9]+ near ".*"\)`)
+			var serr *json.SyntaxError
+			var terr *json.UnmarshalTypeError
+			if test.expectSyntax {
+				qt.Check(t, errors.As(err, &serr), qt.IsTrue)
+				qt.Check(t, serr.Offset, qt.Equals, test.expectOffset)
+			} else {
+				qt.Check(t, errors.As(err, &terr), qt.IsTrue)
+				qt.Check(t, terr.Offset, qt.Equals, test.expectOffset)
+			}
+		})
+	}
+}
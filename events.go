@@ -0,0 +1,181 @@
+package httpjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// An Event is an event received from a server-sent event stream
+// (text/event-stream) by StreamEvents.
+type Event struct {
+	// Type is the type of the event, from its "event" field. If the
+	// event has no type this is "message".
+	Type string
+
+	// ID is the last event ID of the stream when the event was
+	// received, from the "id" field of this or an earlier event.
+	ID string
+
+	// Data contains the data of the event, the values of each of its
+	// "data" fields joined with newlines.
+	Data string
+}
+
+// Decode parses the data of the event as JSON and stores the result in
+// the value pointed to by v, in the same way as json.Unmarshal.
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(e.Data), v)
+}
+
+// ErrEventTooLarge is the error returned by StreamEvents when an event,
+// or a single line of an event stream, is larger than the maximum
+// event size.
+var ErrEventTooLarge = errors.New("event too large")
+
+// defaultMaxEventBytes is the maximum size of an event used by
+// StreamEvents when UnmarshalOptions.MaxEventBytes is zero.
+const defaultMaxEventBytes = 1 << 20
+
+// StreamEvents parses a server-sent event stream (text/event-stream), as
+// defined by the HTML Living Standard, from the body of an http.Response
+// and calls fn with each event, which will typically decode the event's
+// data using Event.Decode. If fn returns an error then parsing stops and
+// the error is returned, otherwise StreamEvents returns nil once the end
+// of the body has been reached.
+//
+// The body is read as fn is called, so an unbounded stream can be
+// processed. Any gzip or deflate Content-Encoding is removed and the
+// body is decoded from the character set specified in the response's
+// Content-Type header, event streams are otherwise UTF-8. Lines may end
+// with "\r\n", "\n" or "\r". Comment lines, such as those sent to keep
+// a connection alive, and events without any data are ignored, as is an
+// incomplete event at the end of the stream. The "retry" field is not
+// used. If the data of an event, or any line, is larger than 1MiB then
+// ErrEventTooLarge is returned, the limit can be changed with
+// UnmarshalOptions.MaxEventBytes.
+func StreamEvents(resp *http.Response, fn func(Event) error) error {
+	return UnmarshalOptions{}.StreamEvents(resp, fn)
+}
+
+// StreamEvents parses a server-sent event stream in the same way as the
+// StreamEvents function, using the options in o.
+func (o UnmarshalOptions) StreamEvents(resp *http.Response, fn func(Event) error) error {
+	r, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	r, err = charsetReader(r, charset)
+	if err != nil {
+		return err
+	}
+	max := o.MaxEventBytes
+	if max <= 0 {
+		max = defaultMaxEventBytes
+	}
+	lr := &lineReader{br: bufio.NewReader(r), max: max}
+	var id, typ string
+	var data strings.Builder
+	for first := true; ; first = false {
+		line, err := lr.readLine()
+		if err == io.EOF {
+			// An event that is not followed by a blank line is
+			// incomplete.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			if data.Len() > 0 {
+				if typ == "" {
+					typ = "message"
+				}
+				e := Event{
+					Type: typ,
+					ID:   id,
+					Data: strings.TrimSuffix(data.String(), "\n"),
+				}
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			typ = ""
+			data.Reset()
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if ok {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "":
+			// A comment.
+		case "data":
+			if int64(data.Len()+len(value)) > max {
+				return ErrEventTooLarge
+			}
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "event":
+			typ = value
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				id = value
+			}
+		}
+	}
+}
+
+// A lineReader reads the lines of an event stream, which may be
+// terminated by "\r\n", "\n" or "\r".
+type lineReader struct {
+	br  *bufio.Reader
+	max int64
+
+	// skipLF is set when the previous line ended with "\r", so that a
+	// following "\n" is treated as part of the same line terminator.
+	// The next byte is not read until the next line is wanted, so that
+	// an event ending with "\r" is not delayed until more data arrives.
+	skipLF bool
+}
+
+// readLine returns the next line, without its terminator. It returns
+// io.EOF if the stream ends before a line is complete, and
+// ErrEventTooLarge if the line is longer than the maximum event size.
+func (r *lineReader) readLine() (string, error) {
+	var line []byte
+	for {
+		c, err := r.br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if r.skipLF {
+			r.skipLF = false
+			if c == '\n' {
+				continue
+			}
+		}
+		switch c {
+		case '\r':
+			r.skipLF = true
+			return string(line), nil
+		case '\n':
+			return string(line), nil
+		}
+		if int64(len(line)) >= r.max {
+			return "", ErrEventTooLarge
+		}
+		line = append(line, c)
+	}
+}
@@ -0,0 +1,201 @@
+package httpjson_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+var streamEventsTests = []struct {
+	name            string
+	contentType     string
+	contentEncoding string
+	body            string
+	expectError     string
+	expectEvents    []httpjson.Event
+}{{
+	name:        "events",
+	contentType: "text/event-stream",
+	body:        "data: {\"s\":\"a\"}\n\nevent: update\nid: 1\ndata: {\"s\":\"b\"}\n\ndata:{\"s\":\"c\"}\n\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: `{"s":"a"}`},
+		{Type: "update", ID: "1", Data: `{"s":"b"}`},
+		{Type: "message", ID: "1", Data: `{"s":"c"}`},
+	},
+}, {
+	name:        "multi-line_data",
+	contentType: "text/event-stream",
+	body:        "data: {\"s\":\ndata: \"a\"}\n\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: "{\"s\":\n\"a\"}"},
+	},
+}, {
+	name:        "comments",
+	contentType: "text/event-stream",
+	body:        ": keepalive\n\n:\ndata: {\"s\":\"a\"}\n: ignored\n\n: keepalive\n\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: `{"s":"a"}`},
+	},
+}, {
+	name:        "crlf",
+	contentType: "text/event-stream",
+	body:        "\ufeffevent: e\r\ndata: {\"s\":\"a\"}\r\n\r\n",
+	expectEvents: []httpjson.Event{
+		{Type: "e", Data: `{"s":"a"}`},
+	},
+}, {
+	name:        "cr",
+	contentType: "text/event-stream",
+	body:        "event: e\rdata: {\"s\":\"a\"}\r\rdata: {\"s\":\"b\"}\r\n\ndata: {\"s\":\"c\"}\n\r",
+	expectEvents: []httpjson.Event{
+		{Type: "e", Data: `{"s":"a"}`},
+		{Type: "message", Data: `{"s":"b"}`},
+		{Type: "message", Data: `{"s":"c"}`},
+	},
+}, {
+	name:        "large_event",
+	contentType: "text/event-stream",
+	body:        strings.Repeat("data: "+strings.Repeat("a", 1<<10)+"\n", 1<<10) + "\n",
+	expectError: `event too large`,
+}, {
+	name:        "large_line",
+	contentType: "text/event-stream",
+	body:        ": " + strings.Repeat("a", 1<<20) + "\n\ndata: {}\n\n",
+	expectError: `event too large`,
+}, {
+	name:        "no_data",
+	contentType: "text/event-stream",
+	body:        "event: e\nid: 2\nretry: 1000\n\ndata: {}\n\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", ID: "2", Data: `{}`},
+	},
+}, {
+	name:        "incomplete",
+	contentType: "text/event-stream",
+	body:        "data: {\"s\":\"a\"}\n\ndata: {\"s\":\"b\"}\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: `{"s":"a"}`},
+	},
+}, {
+	name:        "iso-8859-1",
+	contentType: "text/event-stream;charset=iso-8859-1",
+	body:        "data: {\"s\":\"\xa3\"}\n\n",
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: `{"s":"£"}`},
+	},
+}, {
+	name:            "gzip",
+	contentType:     "text/event-stream",
+	contentEncoding: "gzip",
+	body:            string(gzipBytes("data: {\"s\":\"a\"}\n\n")),
+	expectEvents: []httpjson.Event{
+		{Type: "message", Data: `{"s":"a"}`},
+	},
+}, {
+	name:        "unknown_charset",
+	contentType: "text/event-stream;charset=no-such",
+	body:        "data: {}\n\n",
+	expectError: `ianaindex: invalid encoding name`,
+}}
+
+func TestStreamEvents(t *testing.T) {
+	for _, test := range streamEventsTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type":     {test.contentType},
+					"Content-Encoding": {test.contentEncoding},
+				},
+				Body: io.NopCloser(strings.NewReader(test.body)),
+			}
+			var events []httpjson.Event
+			err := httpjson.StreamEvents(resp, func(e httpjson.Event) error {
+				events = append(events, e)
+				return nil
+			})
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+			} else {
+				qt.Check(t, err, qt.IsNil)
+			}
+			qt.Check(t, events, qt.DeepEquals, test.expectEvents)
+		})
+	}
+}
+
+func TestUnmarshalOptionsStreamEvents(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {"text/event-stream"}},
+		Body:   io.NopCloser(strings.NewReader("data:12\ndata:345\n\ndata:12345\ndata:67890\n\n")),
+	}
+	var events []httpjson.Event
+	err := httpjson.UnmarshalOptions{MaxEventBytes: 10}.StreamEvents(resp, func(e httpjson.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrEventTooLarge)
+	qt.Check(t, events, qt.DeepEquals, []httpjson.Event{{Type: "message", Data: "12\n345"}})
+}
+
+func TestStreamEventsCRNotDelayed(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"s\":\"a\"}\r\r"))
+		w.(http.Flusher).Flush()
+		// Hold the stream open without sending anything more.
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	resp, err := http.Get(srv.URL)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+
+	stop := errors.New("stop")
+	var events []httpjson.Event
+	err = httpjson.StreamEvents(resp, func(e httpjson.Event) error {
+		events = append(events, e)
+		return stop
+	})
+	qt.Check(t, err, qt.Equals, stop)
+	qt.Check(t, events, qt.DeepEquals, []httpjson.Event{{Type: "message", Data: `{"s":"a"}`}})
+}
+
+func TestStreamEventsDecode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream;charset=iso-8859-1")
+		w.Write([]byte(": keepalive\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: {\"s\":\ndata: \"\xa3\"}\n\ndata: {\"s\":\"b\"}\n\n"))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), "GET", srv.URL, nil)
+	qt.Assert(t, err, qt.IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+
+	stop := errors.New("stop")
+	var values []testValue
+	err = httpjson.StreamEvents(resp, func(e httpjson.Event) error {
+		var v testValue
+		if err := e.Decode(&v); err != nil {
+			return err
+		}
+		values = append(values, v)
+		return stop
+	})
+	qt.Check(t, err, qt.Equals, stop)
+	qt.Check(t, values, qt.DeepEquals, []testValue{{S: "£"}})
+}
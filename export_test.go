@@ -0,0 +1,15 @@
+package httpjson
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// NewJSONTransformer returns the transformer used to write JSON in the
+// character set of enc.
+func NewJSONTransformer(enc encoding.Encoding) transform.Transformer {
+	return &jsonTransformer{e: enc.NewEncoder()}
+}
+
+// CheckOrigin checks that rawurl is on the same origin as base.
+var CheckOrigin = checkOrigin
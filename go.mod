@@ -1,8 +1,16 @@
 module github.com/mhilton/httpjson
 
-go 1.16
+go 1.18
 
 require (
 	github.com/frankban/quicktest v1.14.6
 	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.31.0
+)
+
+require (
+	github.com/google/go-cmp v0.5.9 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.9.0 // indirect
 )
@@ -4,8 +4,10 @@ package httpjson
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
@@ -21,17 +23,240 @@ import (
 
 // IsJSONContentType returns whether the given Content-Type is a JSON MIME
 // Type as defined by the WHATWG MIME Sniffing Standard section 4.6
-// (https://mimesniff.spec.whatwg.org/#mime-type-groups).
+// (https://mimesniff.spec.whatwg.org/#mime-type-groups). That is a media
+// type of "application/json" or "text/json", or any type whose subtype
+// ends with the "+json" structured syntax suffix, such as
+// "application/ld+json", "application/vnd.api+json" or
+// "application/json-patch+json". Media types are compared without regard
+// to case or surrounding white space, and parameters are ignored. Other
+// types whose subtype merely starts with "json", such as
+// "application/json-rpc", do not match, nor does a Content-Type that
+// cannot be parsed or that has no subtype. Malformed Content-Types
+// are otherwise tolerated where the media type is unambiguous, see
+// ParseContentType.
 func IsJSONContentType(contentType string) bool {
-	mt, _, err := mime.ParseMediaType(contentType)
+	// ParseContentType removes white space and converts the media type
+	// to lower case.
+	mt, _, err := ParseContentType(contentType)
 	if err != nil {
 		// If it doesn't parse we can't say it's JSON.
 		return false
 	}
-	if mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json") {
-		return true
+	typ, subtype, ok := strings.Cut(mt, "/")
+	if !ok || typ == "" || subtype == "" {
+		return false
+	}
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(subtype, "+json")
+}
+
+// StrictIsJSONContentType returns whether the given Content-Type is
+// "application/json", or an "application" type with a "+json" structured
+// syntax suffix. Unlike IsJSONContentType it does not accept the
+// discouraged "text/json" type. StrictIsJSONContentType may be used as
+// the IsJSONContentType function of a Client to enforce the stricter
+// convention.
+func StrictIsJSONContentType(contentType string) bool {
+	mt, _, err := ParseContentType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json")
+}
+
+// ParseContentType parses the Content-Type header of a received message
+// in the same way as mime.ParseMediaType, returning the media type in
+// lower case and its parameters, but tolerates mistakes made by some
+// servers where the media type can still be determined. The following
+// are accepted:
+//
+//   - parameters that precede the media type, such as
+//     "charset=utf-8; application/json";
+//   - malformed parameters, such as a "charset" without a value or a
+//     parameter containing a space, which are ignored;
+//   - empty parameters, such as in "application/json;;charset=utf-8".
+//
+// A Content-Type without a media type, such as "charset=utf-8", with
+// more than one media type, such as "application/json; text/plain" or
+// "application/json, text/plain", or with conflicting values for a
+// parameter results in an error describing the problem. This package
+// uses ParseContentType for the Content-Type of every message it
+// decodes.
+func ParseContentType(contentType string) (mediatype string, params map[string]string, err error) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		return mt, params, nil
+	}
+	mt = ""
+	params = make(map[string]string)
+	for _, p := range strings.Split(contentType, ";") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case strings.Contains(p, "="):
+			_, pp, err := mime.ParseMediaType("x/x;" + p)
+			if err != nil {
+				// A malformed parameter.
+				continue
+			}
+			for k, v := range pp {
+				if v0, ok := params[k]; ok && v0 != v {
+					return "", nil, fmt.Errorf("invalid Content-Type %q: duplicate parameter %q", contentType, k)
+				}
+				params[k] = v
+			}
+		case strings.Contains(p, "/"):
+			if mt != "" {
+				return "", nil, fmt.Errorf("invalid Content-Type %q: more than one media type", contentType)
+			}
+			var err error
+			mt, _, err = mime.ParseMediaType(p)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid Content-Type %q: %v", contentType, err)
+			}
+		}
+	}
+	if mt == "" {
+		return "", nil, fmt.Errorf("invalid Content-Type %q: no media type", contentType)
+	}
+	return mt, params, nil
+}
+
+// A ContentTyper is a value that knows the media type it should be
+// transported as. When MarshalRequest or WriteResponse is called without
+// a content type and the value implements ContentTyper the result of
+// the ContentType method is used as the content type.
+type ContentTyper interface {
+	// ContentType returns the Content-Type, including any charset
+	// parameter, with which the value should be sent.
+	ContentType() string
+}
+
+// A RawBody is a request body that has already been encoded. When a
+// RawBody is passed as the value of MarshalRequest, or of a Client
+// request, the data read from Reader is sent as the request body
+// unchanged, without being marshaled, re-encoded or compressed. The
+// data must already be in the character set of the request's content
+// type.
+//
+// If Reader is a *bytes.Buffer, *bytes.Reader or *strings.Reader, or
+// otherwise implements both io.Seeker and io.ReaderAt, such as an
+// *os.File, the request has its Content-Length set and a GetBody method
+// so that it can be redirected or retried. Such a Reader is not closed
+// when the request is sent, even if it implements io.Closer, so the
+// caller must close it once the request has completed. A Reader that
+// implements io.Seeker but not io.ReaderAt has its Content-Length set
+// but no GetBody method, as it cannot be read again independently of
+// the request's Body. Other readers, such as the body of an upstream
+// response being forwarded, are streamed with an unknown length and are
+// read only once.
+type RawBody struct {
+	Reader io.Reader
+}
+
+// newRawRequest creates a new http.Request with the given context,
+// method and URL that sends body unchanged.
+func newRawRequest(ctx context.Context, method, url string, body RawBody) (*http.Request, error) {
+	r := body.Reader
+	switch r.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader, nil:
+		// http.NewRequest determines the length of these itself.
+		return http.NewRequestWithContext(ctx, method, url, r)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return req, nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	n := end - start
+	req.ContentLength = n
+	if n == 0 {
+		req.Body = http.NoBody
 	}
-	return false
+	// GetBody must return a reader that is independent of req.Body,
+	// which may not have been read yet, so a plain io.Seeker cannot be
+	// used.
+	if ra, ok := r.(io.ReaderAt); ok {
+		if n > 0 {
+			// The transport closes the body once it has been sent,
+			// which must not close a reader that will be read again.
+			req.Body = io.NopCloser(r)
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(ra, start, n)), nil
+		}
+	}
+	return req, nil
+}
+
+// valueContentType determines the content type with which to send v. If
+// contentType is not empty it is used, otherwise the value's own content
+// type is used if it is a ContentTyper, falling back to
+// o.DefaultContentType and then "application/json;charset=utf-8".
+func (o MarshalOptions) valueContentType(contentType string, v interface{}) string {
+	if contentType != "" {
+		return contentType
+	}
+	if ct, ok := v.(ContentTyper); ok {
+		if contentType = ct.ContentType(); contentType != "" {
+			return contentType
+		}
+	}
+	if o.DefaultContentType != "" {
+		return o.DefaultContentType
+	}
+	return "application/json;charset=utf-8"
+}
+
+// MergeContentType combines a default content type with a content type
+// given for a particular message. If contentType is empty def is
+// returned. If contentType has no media type, for example
+// ";charset=utf-8", the result has the media type and parameters of def
+// with any parameters from contentType replacing those in def. If
+// contentType has a media type but no charset parameter the result is
+// contentType with the charset of def, if any. Otherwise contentType is
+// returned unchanged. If either content type cannot be parsed
+// contentType is returned unchanged.
+func MergeContentType(def, contentType string) string {
+	if contentType == "" {
+		return def
+	}
+	dmt, dparams, err := mime.ParseMediaType(def)
+	if err != nil {
+		return contentType
+	}
+	if strings.HasPrefix(strings.TrimSpace(contentType), ";") {
+		_, params, err := mime.ParseMediaType(dmt + contentType)
+		if err != nil {
+			return contentType
+		}
+		for k, v := range params {
+			dparams[k] = v
+		}
+		return mime.FormatMediaType(dmt, dparams)
+	}
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if _, ok := params["charset"]; ok || dparams["charset"] == "" {
+		return contentType
+	}
+	params["charset"] = dparams["charset"]
+	return mime.FormatMediaType(mt, params)
 }
 
 // MarshalRequest creates a new http.Request with the given method and URL
@@ -39,31 +264,185 @@ func IsJSONContentType(contentType string) bool {
 //
 // If v is nil then the request will have no body. Otherwise v will be
 // marshaled and then encoded using the character set specified by
-// contentType. If the contentType is empty then the default contentType of
-// "application/json;charset=utf-8" is used. If the contentType doesn't
-// specify a character set then the value will be encoded as "us-ascii".
+// contentType. If the contentType is empty then the content type of a v
+// that implements ContentTyper is used, otherwise the default
+// contentType of "application/json;charset=utf-8" is used. If the
+// contentType doesn't specify a character set then the value will be
+// encoded as "us-ascii", see MarshalOptions.DefaultCharset to change
+// this.
 //
 // For a non-nil v the request will have the "Content-Length" and
 // "Content-Type" headers set and include a GetBody method to support
 // redirection.
+//
+// If v is a RawBody its data is sent unchanged rather than being
+// marshaled.
+//
+// The request has the background context, MarshalRequestContext creates
+// a request with a given context, so that it does not have to be set
+// with WithContext.
 func MarshalRequest(method, url, contentType string, v interface{}) (*http.Request, error) {
-	if contentType == "" {
-		contentType = `application/json;charset=utf-8`
+	return MarshalOptions{}.MarshalRequest(method, url, contentType, v)
+}
+
+// MarshalRequestContext creates a new http.Request with the given
+// context, method and URL and a body containing the JSON encoding of v,
+// in the same way as MarshalRequest. The context controls the entire
+// lifetime of the request and its response, as with
+// http.NewRequestWithContext.
+func MarshalRequestContext(ctx context.Context, method, url, contentType string, v interface{}) (*http.Request, error) {
+	return MarshalOptions{}.MarshalRequestContext(ctx, method, url, contentType, v)
+}
+
+// MarshalOptions contains options that control the encoding of JSON
+// message bodies. The zero value is equivalent to the behaviour of the
+// package level functions.
+type MarshalOptions struct {
+	// PlainIntegers causes numbers that have an integer value to be
+	// written without an exponent, so that, for example, a float64 with
+	// the value 1e21 is written as 1000000000000000000000 rather than
+	// 1e+21. Numbers that are not integers are unchanged.
+	PlainIntegers bool
+
+	// GzipMinBytes, if greater than zero, causes encoded request bodies
+	// of at least this many bytes to be gzip compressed before they are
+	// sent, with the Content-Encoding header set to "gzip" and the
+	// Content-Length set to the compressed size. It has no effect on
+	// responses, which can be compressed using
+	// WriteResponseCompressed.
+	GzipMinBytes int
+
+	// ExpectContinueBytes, if greater than zero, causes requests with a
+	// body of at least this many bytes, as sent, to have an
+	// "Expect: 100-continue" header, so that the body is only sent once
+	// the server has responded with "100 Continue". A server that
+	// rejects the request, for example because it is unauthorized or
+	// too large, can then respond without the body being sent. The
+	// http.Transport must have a non-zero ExpectContinueTimeout, which
+	// is the time it waits for the interim response before sending the
+	// body anyway, otherwise the body is sent immediately;
+	// http.DefaultTransport waits for one second. A body whose length
+	// is not known in advance never has the header. If a redirect
+	// requires the body to be sent again the header is copied to the
+	// new request and the body is recreated using the request's
+	// GetBody method, a request without GetBody is not redirected. It
+	// has no effect on responses.
+	ExpectContinueBytes int64
+
+	// Indent, if not empty, causes the JSON to be indented in the same
+	// way as json.MarshalIndent, with each element of an object or
+	// array beginning on a new line starting with IndentPrefix followed
+	// by one or more copies of Indent according to the nesting depth.
+	// The indented document is encoded into the message's character
+	// set in the same way as a compact one.
+	Indent string
+
+	// IndentPrefix is the prefix of each indented line, it is only
+	// used if Indent is not empty.
+	IndentPrefix string
+
+	// DefaultContentType is the content type with which a value is
+	// sent when no content type is given for the call and the value
+	// does not implement ContentTyper. If this is empty
+	// "application/json;charset=utf-8" is used. A content type given
+	// for the call takes precedence over the value's own content type,
+	// which takes precedence over DefaultContentType. Setting, for
+	// example, "application/json" along with a DefaultCharset of
+	// "utf-8" sends UTF-8 bodies without a charset parameter.
+	DefaultContentType string
+
+	// DefaultCharset is the character set in which a body is encoded
+	// when its content type does not specify one. If this is empty
+	// "us-ascii" is used, with every non-ASCII character escaped.
+	// Setting it to "utf-8" sends such characters unescaped, which
+	// a recipient following RFC 8259 will accept, as JSON without a
+	// charset is UTF-8. The content type is sent unchanged, without a
+	// charset parameter being added.
+	DefaultCharset string
+
+	// DisableHTMLEscape stops the characters <, > and & in strings from
+	// being escaped as \u003c, \u003e and \u0026, which json.Marshal
+	// does so that JSON can be safely embedded in HTML. Non-ASCII
+	// characters are still escaped when the message's character set
+	// cannot represent them.
+	DisableHTMLEscape bool
+
+	// EscapeNonASCII causes every non-ASCII character in a UTF-8
+	// message to be escaped, in the same way as they are for a message
+	// encoded as "us-ascii", for recipients that cannot handle
+	// multi-byte characters. Characters outside the Basic Multilingual
+	// Plane are escaped as a UTF-16 surrogate pair, for example U+1F600
+	// is written as \ud83d\ude00. The content type is unchanged. It
+	// has no effect on messages in other character sets, whose
+	// encoding already determines which characters are escaped.
+	EscapeNonASCII bool
+
+	// OmitContentLength stops WriteResponse setting the Content-Length
+	// header of the response, so that the length can be determined by
+	// the http.ResponseWriter. This is required when w is a wrapper
+	// that changes the body, such as gzip compression middleware, as
+	// the length of the encoded body would not match the length sent.
+	// Without a Content-Length an http.Server computes the length of a
+	// small body itself and sends a larger one using chunked transfer
+	// encoding. It has no effect on requests.
+	OmitContentLength bool
+
+	// MarshalFunc, if not nil, is used to produce the JSON encoding of
+	// values in place of encoding/json, allowing an alternative JSON
+	// implementation to be used. It must return a single UTF-8 encoded
+	// JSON document, which is then indented, has PlainIntegers applied
+	// and is encoded into the character set of the message in the same
+	// way as the output of encoding/json. DisableHTMLEscape has no
+	// effect, the escaping of HTML characters is determined by
+	// MarshalFunc.
+	MarshalFunc func(v interface{}) ([]byte, error)
+}
+
+// MarshalRequest creates a new http.Request in the same way as the
+// MarshalRequest function, using the options in o.
+func (o MarshalOptions) MarshalRequest(method, url, contentType string, v interface{}) (*http.Request, error) {
+	return o.MarshalRequestContext(context.Background(), method, url, contentType, v)
+}
+
+// MarshalRequestContext creates a new http.Request in the same way as
+// the MarshalRequestContext function, using the options in o.
+func (o MarshalOptions) MarshalRequestContext(ctx context.Context, method, url, contentType string, v interface{}) (*http.Request, error) {
+	contentType = o.valueContentType(contentType, v)
+	if rb, ok := v.(RawBody); ok {
+		req, err := newRawRequest(ctx, method, url, rb)
+		if err != nil {
+			return nil, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Header.Set("Content-Type", contentType)
+		}
+		o.expectContinue(req)
+		return req, nil
 	}
 	var body []byte
 	if v != nil {
-		_, mtParam, _ := mime.ParseMediaType(contentType)
-		var err error
-		body, err = marshal(mtParam["charset"], v)
+		charset, err := contentCharset(contentType)
+		if err != nil {
+			return nil, err
+		}
+		body, err = o.marshal(charset, v)
 		if err != nil {
 			return nil, err
 		}
 	}
+	compressed := o.compress(body)
+	if compressed {
+		var buf bytes.Buffer
+		if err := gzipTo(&buf, body); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
 	var r io.Reader
 	if body != nil {
 		r = bytes.NewReader(body)
 	}
-	req, err := http.NewRequest(method, url, r)
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
 	if err != nil {
 		return nil, err
 	}
@@ -74,57 +453,227 @@ func MarshalRequest(method, url, contentType string, v interface{}) (*http.Reque
 			return io.NopCloser(bytes.NewReader(body)), nil
 		}
 	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	o.expectContinue(req)
 	return req, nil
 }
 
+// compress determines whether the encoded request body should be
+// compressed.
+func (o MarshalOptions) compress(body []byte) bool {
+	return o.GzipMinBytes > 0 && body != nil && len(body) >= o.GzipMinBytes
+}
+
+// expectContinue adds an "Expect: 100-continue" header to req if its
+// body is large enough according to ExpectContinueBytes.
+func (o MarshalOptions) expectContinue(req *http.Request) {
+	if o.ExpectContinueBytes > 0 && req.ContentLength >= o.ExpectContinueBytes {
+		req.Header.Set("Expect", "100-continue")
+	}
+}
+
 // UnmarshalRequest parses the JSON-encoded body of an http.Request and
 // stores the result in the value pointed to by v.
 //
-// UnmarshalRequest decodes the request body from the character set
-// specified in the request's Content-Type header before parsing the JSON
-// value.
+// UnmarshalRequest removes any gzip or deflate Content-Encoding from the
+// request body and then decodes it from the character set specified in
+// the request's Content-Type header before parsing the JSON value. A
+// Content-Type with conflicting charset parameters is rejected with an
+// error matching ErrDuplicateCharset, without the body being read.
+//
+// UnmarshalRequest does not limit the size of an uncompressed body it
+// reads, use UnmarshalOptions to set a limit. A compressed body is
+// limited to 10MiB once decompressed, so that a small request cannot
+// expand without bound, a larger body results in ErrRequestTooLarge.
 func UnmarshalRequest(req *http.Request, v interface{}) error {
-	buf, err := io.ReadAll(req.Body)
+	return UnmarshalOptions{}.UnmarshalRequest(req, v)
+}
+
+// UnmarshalOptions contains options that control the decoding of JSON
+// message bodies. The zero value is equivalent to the behaviour of the
+// package level functions.
+type UnmarshalOptions struct {
+	// MaxBodyBytes is the maximum size of a message body that will be
+	// decoded. If the body is compressed the limit applies to the
+	// decompressed body, protecting against decompression bombs. If a
+	// request body exceeds this size ErrRequestTooLarge is returned, if
+	// a response body exceeds it ErrResponseTooLarge is returned. If
+	// this is zero then there is no limit, except that a compressed
+	// request body is limited to 10MiB once decompressed.
+	MaxBodyBytes int64
+
+	// DisallowUnknownFields causes an error to be returned when a JSON
+	// object contains a key that does not match any exported field of
+	// the struct it is being decoded into, in the same way as
+	// json.Decoder.DisallowUnknownFields.
+	DisallowUnknownFields bool
+
+	// UseNumber causes numbers decoded into an interface{} to be
+	// stored as a json.Number, rather than a float64, in the same way
+	// as json.Decoder.UseNumber. This preserves the exact value of
+	// integers too large to be represented by a float64.
+	UseNumber bool
+
+	// RequireJSONContentType causes a message that does not have a
+	// JSON Content-Type, according to IsJSONContentType, to be rejected
+	// without reading its body. The returned error matches
+	// ErrUnsupportedMediaType using errors.Is, so that a server can
+	// respond with 415 Unsupported Media Type.
+	RequireJSONContentType bool
+
+	// AllowEmptyBody causes a body that is empty, or contains only
+	// white space, to be accepted, leaving v unchanged, rather than
+	// ErrEmptyBody being returned.
+	AllowEmptyBody bool
+
+	// SniffCharset causes the character set of a body whose
+	// Content-Type has no charset parameter to be detected, rather than
+	// the body being assumed to be UTF-8. A body starting with a UTF-16
+	// byte order mark, or with the pattern of zero bytes that ASCII
+	// characters produce in UTF-16, is decoded as UTF-16. Otherwise a
+	// body that is not valid UTF-8 is decoded as windows-1252, the
+	// superset of ISO 8859-1 that is commonly sent without being
+	// declared. A charset parameter in the Content-Type always takes
+	// precedence, even if the body is not valid in that charset.
+	SniffCharset bool
+
+	// UnmarshalFunc, if not nil, is used to parse JSON documents in place
+	// of encoding/json, allowing an alternative JSON implementation to
+	// be used. It is called with the body after it has been decoded
+	// into UTF-8 and had any byte order mark removed, in the same way
+	// as json.Unmarshal. DisallowUnknownFields and UseNumber have no
+	// effect, the equivalent options must be configured in
+	// UnmarshalFunc.
+	UnmarshalFunc func(data []byte, v interface{}) error
+
+	// CheckTrailer, if not nil, is called by UnmarshalResponse with the
+	// response's trailer once the whole of the body has been read, and
+	// so the trailer has been received, but before the body is parsed.
+	// This allows, for example, a checksum sent in the trailer to be
+	// verified. If CheckTrailer returns an error then it is returned by
+	// UnmarshalResponse without v being modified.
+	CheckTrailer func(trailer http.Header) error
+
+	// MaxEventBytes is the maximum size of the data of an event, or of
+	// a single line, read by StreamEvents. If it is exceeded
+	// ErrEventTooLarge is returned. If this is zero the limit is 1MiB.
+	MaxEventBytes int64
+}
+
+// ErrEmptyBody is the error returned when a message body that should
+// contain a JSON value is empty, or contains only white space, so that
+// a caller can distinguish a missing body from a malformed one. See
+// UnmarshalOptions.AllowEmptyBody to accept such bodies.
+var ErrEmptyBody = errors.New("empty body")
+
+// UnmarshalRequest parses the JSON-encoded body of an http.Request in the
+// same way as the UnmarshalRequest function, using the options in o.
+func (o UnmarshalOptions) UnmarshalRequest(req *http.Request, v interface{}) error {
+	if err := o.checkContentType(req.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+	r, err := decompress(req.Body, req.Header.Get("Content-Encoding"))
 	if err != nil {
 		return err
 	}
-	_, mtParam, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
-	return unmarshal(buf, mtParam["charset"], v)
+	charset, err := contentCharset(req.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	max := o.MaxBodyBytes
+	if max <= 0 && r != req.Body {
+		max = defaultMaxDecompressedBytes
+	}
+	buf, err := readAll(limit(r, max, ErrRequestTooLarge), sizeHint(req.Header, req.ContentLength, max))
+	if err != nil {
+		return err
+	}
+	return o.unmarshal(buf, charset, v)
 }
 
 // WriteResponse writes the JSON encoding of v as the body of an HTTP
 // response.
 //
 // The marshaled value will be encoded using the character set specified in
-// the contentType. If the contentType is empty then the default
+// the contentType. If the contentType is empty then the content type of a
+// v that implements ContentTyper is used, otherwise the default
 // contentType of "application/json;charset=utf-8" is used. If the
 // contentType doesn't specify a character set then the value will be
-// encoded as "us-ascii".
+// encoded as "us-ascii", see MarshalOptions.DefaultCharset to change
+// this.
+//
+// If v is a json.RawMessage containing a valid JSON document then the
+// document is written without being marshaled again, so its formatting
+// is preserved, it is still encoded into the character set of the
+// response. An invalid json.RawMessage results in an error.
 //
 // If v is nil then WriteResponse will write an empty body, otherwise
 // WriteResponse will set the Content-Length and Content-Type headers
-// before writing the response body.
+// before writing the response body, see MarshalOptions.OmitContentLength
+// to leave the Content-Length unset.
 //
 // If statusCode is > 0 then WriteResponse will call w.WriteHeader with the
-// status code before writing the body.
+// status code before writing the body. If v is nil and statusCode is 0
+// then WriteResponse does nothing.
+//
+// WriteResponse should be called at most once for a response, and not
+// after the handler has called w.WriteHeader or w.Write. A
+// ResponseWriter does not report whether its header has been written,
+// so WriteResponse cannot detect this. Once the header has been
+// written, which happens at the latest on the first call to w.Write,
+// any change to the headers is ignored, so the status code and headers
+// of a later call are lost, and an http.Server logs a "superfluous
+// response.WriteHeader call" warning. The body of a second call to
+// WriteResponse is not written, as it exceeds the Content-Length set by
+// the first, and the error returned by w.Write, http.ErrContentLength,
+// is returned. Calling WriteResponse after w.Write appends its body to
+// the data already written, producing a body that is not valid JSON.
 func WriteResponse(w http.ResponseWriter, statusCode int, contentType string, v interface{}) error {
-	if contentType == "" {
-		contentType = "application/json;charset=utf-8"
+	return MarshalOptions{}.WriteResponse(w, statusCode, contentType, v)
+}
+
+// WriteResponse writes the JSON encoding of v as the body of an HTTP
+// response in the same way as the WriteResponse function, using the
+// options in o.
+func (o MarshalOptions) WriteResponse(w http.ResponseWriter, statusCode int, contentType string, v interface{}) error {
+	contentType = o.valueContentType(contentType, v)
+	if v == nil {
+		return o.writeBody(w, statusCode, contentType, nil)
 	}
-	var body []byte
-	if v != nil {
-		_, mtParam, _ := mime.ParseMediaType(contentType)
-		var err error
-		body, err = marshal(mtParam["charset"], v)
-		if err != nil {
-			return err
-		}
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		return err
+	}
+	// The body is only needed until it has been written, so it can be
+	// encoded into a pooled buffer.
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := o.marshalTo(buf, charset, v); err != nil {
+		return err
+	}
+	return o.writeBody(w, statusCode, contentType, buf.Bytes())
+}
+
+// writeBody writes the given response body. If body is not nil the
+// Content-Type header, and unless o.OmitContentLength is set the
+// Content-Length header, are set before the header is written.
+func (o MarshalOptions) writeBody(w http.ResponseWriter, statusCode int, contentType string, body []byte) error {
+	if body != nil {
 		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Content-Length", strconv.FormatInt(int64(len(body)), 10))
+		if !o.OmitContentLength {
+			w.Header().Set("Content-Length", strconv.FormatInt(int64(len(body)), 10))
+		}
 	}
 	if statusCode > 0 {
 		w.WriteHeader(statusCode)
 	}
+	if len(body) == 0 {
+		// Write fails for a status that does not allow a body, such
+		// as 204 No Content, even when there is nothing to write.
+		return nil
+	}
 	_, err := w.Write(body)
 	return err
 }
@@ -132,30 +681,239 @@ func WriteResponse(w http.ResponseWriter, statusCode int, contentType string, v
 // UnmarshalResponse parses the JSON-encoded body of an http.Response and
 // stores the result in the value pointed to by v.
 //
-// UnmarshalResponse decodes the response body from the character set
-// specified in the reponse's Content-Type header before parsing the JSON
-// value.
+// UnmarshalResponse removes any gzip or deflate Content-Encoding from the
+// response body, which is necessary when the transport has not done so,
+// for example because the request set its own Accept-Encoding header.
+// The body is then decoded from the character set specified in the
+// reponse's Content-Type header before parsing the JSON value. A body
+// that is empty, or contains only white space, results in ErrEmptyBody.
+// A Content-Type with conflicting charset parameters results in
+// ErrDuplicateCharset, and data following the end of a compressed body
+// results in ErrTrailingData.
+//
+// If resp was received using an http.Client then canceling the context
+// of the request aborts reading the body, UnmarshalResponse then returns
+// an error that matches the context's error using errors.Is.
+//
+// The body is always read to its end, so once UnmarshalResponse has
+// returned without error any trailer sent after a chunked body has been
+// stored in resp.Trailer, as net/http only populates the trailer when
+// the body has been drained. See UnmarshalOptions.CheckTrailer to
+// inspect the trailer before the body is parsed.
 func UnmarshalResponse(resp *http.Response, v interface{}) error {
-	buf, err := io.ReadAll(resp.Body)
+	return UnmarshalOptions{}.UnmarshalResponse(resp, v)
+}
+
+// UnmarshalResponse parses the JSON-encoded body of an http.Response in
+// the same way as the UnmarshalResponse function, using the options in
+// o.
+func (o UnmarshalOptions) UnmarshalResponse(resp *http.Response, v interface{}) error {
+	if err := o.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+	r, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	buf, err := readAll(limit(r, o.MaxBodyBytes, ErrResponseTooLarge), sizeHint(resp.Header, resp.ContentLength, o.MaxBodyBytes))
 	if err != nil {
 		return err
 	}
-	_, mtParam, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
-	return unmarshal(buf, mtParam["charset"], v)
+	// A decompressor can stop reading at the end of the compressed
+	// data, before the transport has seen the trailer, so ensure the
+	// end of the underlying body has been reached. Any data after the
+	// end of the compressed data is an error.
+	var extra [1]byte
+	if n, err := io.ReadFull(resp.Body, extra[:]); n > 0 {
+		return ErrTrailingData
+	} else if err != io.EOF {
+		return err
+	}
+	if o.CheckTrailer != nil {
+		if err := o.CheckTrailer(resp.Trailer); err != nil {
+			return err
+		}
+	}
+	return o.unmarshal(buf, charset, v)
 }
 
-func marshal(charset string, v interface{}) ([]byte, error) {
-	if charset == "" {
-		// If the character-set isn't specified the default is us-ascii.
-		charset = "us-ascii"
+// ResponseCharset returns the character set in which the body of resp is
+// encoded, as used by UnmarshalResponse and Client to decode it. This is
+// the charset parameter of the response's Content-Type header, or
+// "utf-8" if there is none. Together with Client.DoResponse this allows
+// a document to be sent back in the character set it was received in,
+// for example:
+//
+//	resp, err := client.DoResponse(ctx, "GET", url, "", nil, &v)
+//	...
+//	ct := "application/json;charset=" + httpjson.ResponseCharset(resp)
+//	err = client.Do(ctx, "PUT", url, ct, v, nil)
+func ResponseCharset(resp *http.Response) string {
+	if charset, _ := contentCharset(resp.Header.Get("Content-Type")); charset != "" {
+		return charset
 	}
-	buf, err := json.Marshal(v)
+	return "utf-8"
+}
+
+// Marshal returns the JSON encoding of v encoded in the character set
+// specified by contentType, exactly as it would be sent by
+// MarshalRequest or WriteResponse. If the contentType is empty then the
+// content type of a v that implements ContentTyper is used, otherwise
+// the default contentType of "application/json;charset=utf-8" is used.
+// If the contentType doesn't specify a character set then the value
+// will be encoded as "us-ascii", with any non-ASCII characters escaped.
+// A value encoded as "utf-8" is byte for byte the same as the output of
+// json.Marshal, with non-ASCII characters unescaped, see
+// MarshalOptions.EscapeNonASCII to escape them.
+func Marshal(contentType string, v interface{}) ([]byte, error) {
+	return MarshalOptions{}.Marshal(contentType, v)
+}
+
+// Marshal returns the JSON encoding of v in the same way as the Marshal
+// function, using the options in o.
+func (o MarshalOptions) Marshal(contentType string, v interface{}) ([]byte, error) {
+	charset, err := contentCharset(o.valueContentType(contentType, v))
 	if err != nil {
 		return nil, err
 	}
-	if strings.EqualFold(charset, "utf-8") {
+	return o.marshal(charset, v)
+}
+
+// Unmarshal parses the JSON value in buf, which is encoded in the
+// character set specified by contentType, and stores the result in the
+// value pointed to by v. If contentType doesn't specify a character set
+// then buf is assumed to be UTF-8.
+func Unmarshal(buf []byte, contentType string, v interface{}) error {
+	return UnmarshalOptions{}.Unmarshal(buf, contentType, v)
+}
+
+// Unmarshal parses the JSON value in buf in the same way as the
+// Unmarshal function, using the options in o. MaxBodyBytes does not
+// apply as buf has already been read.
+func (o UnmarshalOptions) Unmarshal(buf []byte, contentType string, v interface{}) error {
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		return err
+	}
+	return o.unmarshal(buf, charset, v)
+}
+
+// ErrTrailingData is the error returned by UnmarshalResponse when a
+// compressed response body contains data after the end of the
+// compressed stream.
+var ErrTrailingData = errors.New("data after end of compressed body")
+
+// ErrDuplicateCharset is the error returned when the body of a message
+// is not encoded or decoded because its Content-Type has more than one
+// charset parameter with different values, such as
+// "application/json; charset=utf-8; charset=iso-8859-1", as it cannot be
+// known which character set the body is encoded in. Repeated charset
+// parameters with the same value, compared without regard to case, are
+// accepted.
+var ErrDuplicateCharset = errors.New("duplicate charset parameter")
+
+// contentCharset returns the charset parameter of contentType, without
+// any quotes or surrounding white space, which is empty if there is
+// none. The charset is found even if other parts of contentType cannot
+// be parsed, a malformed charset parameter is ignored. If contentType
+// has charset parameters with different values, compared without
+// regard to case, an error that matches ErrDuplicateCharset is
+// returned.
+func contentCharset(contentType string) (string, error) {
+	var charset string
+	for _, p := range strings.Split(contentType, ";") {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "charset") {
+			continue
+		}
+		// Use ParseMediaType to remove any quoting from the value.
+		_, params, err := mime.ParseMediaType("x/x;charset=" + strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(params["charset"])
+		if charset != "" && !strings.EqualFold(charset, v) {
+			return "", fmt.Errorf("invalid Content-Type %q: %w", contentType, ErrDuplicateCharset)
+		}
+		charset = v
+	}
+	return charset, nil
+}
+
+// checkContentType checks that a message with the given Content-Type
+// can be decoded.
+func (o UnmarshalOptions) checkContentType(contentType string) error {
+	if o.RequireJSONContentType && !IsJSONContentType(contentType) {
+		return mediaTypeError(contentType)
+	}
+	return nil
+}
+
+// A mediaTypeError is the error returned when a message is rejected
+// because of its Content-Type.
+type mediaTypeError string
+
+// Error implements error.
+func (e mediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported Content-Type %q", string(e))
+}
+
+// Is returns true if target is ErrUnsupportedMediaType.
+func (e mediaTypeError) Is(target error) bool {
+	return target == ErrUnsupportedMediaType
+}
+
+func (o MarshalOptions) marshal(charset string, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := o.marshalTo(&buf, charset, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalTo writes the JSON encoding of v, encoded in the given character
+// set, to dst.
+func (o MarshalOptions) marshalTo(dst *bytes.Buffer, charset string, v interface{}) error {
+	enc, encErr := o.encoding(charset)
+	if encErr == nil && enc == nil {
 		// The native format is "utf-8", there is no need to encode it.
-		return buf, nil
+		return o.encodeJSON(dst, v)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := o.encodeJSON(buf, v); err != nil {
+		return err
+	}
+	if encErr != nil {
+		return encErr
+	}
+	return transformTo(dst, &jsonTransformer{e: enc.NewEncoder()}, buf.Bytes())
+}
+
+// encoding returns the encoding for the given character set, applying
+// the default if charset is empty. The returned encoding is nil if the
+// character set is "utf-8", which needs no encoding, unless
+// EscapeNonASCII is set.
+func (o MarshalOptions) encoding(charset string) (encoding.Encoding, error) {
+	charset = strings.TrimSpace(charset)
+	if charset == "" {
+		charset = o.DefaultCharset
+	}
+	if charset == "" {
+		// If the character-set isn't specified the default is us-ascii.
+		charset = "us-ascii"
+	}
+	if strings.EqualFold(charset, "utf-8") {
+		if !o.EscapeNonASCII {
+			return nil, nil
+		}
+		// ASCII is a subset of UTF-8, so encoding as ASCII escapes
+		// every other character.
+		charset = "us-ascii"
 	}
 	enc, err := ianaindex.MIME.Encoding(charset)
 	if err != nil {
@@ -164,18 +922,106 @@ func marshal(charset string, v interface{}) ([]byte, error) {
 	if enc == nil {
 		return nil, errors.New("marshal: unsupported encoding")
 	}
-	encoder := &encoding.Encoder{
-		Transformer: jsonTransformer{e: enc.NewEncoder()},
+	return enc, nil
+}
+
+// transformTo appends the result of transforming src with t to dst. The
+// transformed data is written directly into dst's spare capacity, which
+// avoids the intermediate buffers allocated by transform.Writer.
+func transformTo(dst *bytes.Buffer, t transform.Transformer, src []byte) error {
+	// Most characters transform to a single byte, escapes need more.
+	dst.Grow(len(src) + 16)
+	for {
+		b := dst.Bytes()
+		free := b[len(b):cap(b)]
+		nDst, nSrc, err := t.Transform(free, src, true)
+		dst.Write(free[:nDst])
+		src = src[nSrc:]
+		switch err {
+		case nil:
+			return nil
+		case transform.ErrShortDst:
+			dst.Grow(len(src) + 16)
+		default:
+			return err
+		}
 	}
-	return encoder.Bytes(buf)
 }
 
+// encodeJSON writes the JSON encoding of v to buf. Unless modified by the
+// options the output is the same as that produced by json.Marshal,
+// except that a valid json.RawMessage is written as it is, without
+// being compacted or having HTML characters escaped.
+func (o MarshalOptions) encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	n := buf.Len()
+	if raw, ok := v.(json.RawMessage); ok && json.Valid(raw) && utf8.Valid(raw) {
+		if err := o.writeRaw(buf, raw); err != nil {
+			return err
+		}
+	} else if o.MarshalFunc != nil {
+		data, err := o.MarshalFunc(v)
+		if err != nil {
+			return err
+		}
+		if err := o.writeRaw(buf, data); err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(!o.DisableHTMLEscape)
+		if o.Indent != "" {
+			enc.SetIndent(o.IndentPrefix, o.Indent)
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// Remove the trailing newline added by the encoder.
+		buf.Truncate(buf.Len() - 1)
+	}
+	if o.PlainIntegers {
+		b := plainIntegers(buf.Bytes()[n:])
+		buf.Truncate(n)
+		buf.Write(b)
+	}
+	return nil
+}
+
+// writeRaw writes the valid JSON document raw to buf, indenting it if
+// required by the options.
+func (o MarshalOptions) writeRaw(buf *bytes.Buffer, raw json.RawMessage) error {
+	raw = bytes.TrimSpace(raw)
+	if o.Indent != "" {
+		return json.Indent(buf, raw, o.IndentPrefix, o.Indent)
+	}
+	buf.Write(raw)
+	return nil
+}
+
+// A jsonTransformer encodes UTF-8 encoded JSON into the character set of
+// an encoding, escaping any character that the character set cannot
+// represent.
 type jsonTransformer struct {
 	e *encoding.Encoder
+
+	// pending contains the end of an encoded escape sequence that did
+	// not fit in the destination buffer, it is written at the start of
+	// the next call to Transform.
+	pending []byte
+
+	// escaped holds the encoded form of the most recent escape
+	// sequence, pending refers to its contents.
+	escaped [48]byte
 }
 
 // Transform implements encoding.Transformer.
-func (t jsonTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+func (t *jsonTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if len(t.pending) > 0 {
+		nDst = copy(dst, t.pending)
+		t.pending = t.pending[nDst:]
+		if len(t.pending) > 0 {
+			return nDst, 0, transform.ErrShortDst
+		}
+	}
 	for {
 		nd, ns, err := t.e.Transformer.Transform(dst[nDst:], src[nSrc:], atEOF)
 		nDst += nd
@@ -193,21 +1039,29 @@ func (t jsonTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int,
 			// Can only get a rune error with a short src.
 			return nDst, nSrc, transform.ErrShortSrc
 		}
-		buf := make([]byte, 6, 12)
+		var buf [12]byte
+		n := 6
 		if r < 0x10000 {
 			escape(buf[:], r)
 		} else {
-			buf = buf[:12]
+			n = 12
 			r1, r2 := utf16.EncodeRune(r)
 			escape(buf[:6], r1)
 			escape(buf[6:], r2)
 		}
-		nd, _, err = t.e.Transformer.Transform(dst[nDst:], buf[:], false)
+		nd, _, err = t.e.Transformer.Transform(t.escaped[:], buf[:n], false)
 		if err != nil {
 			return nDst, nSrc, err
 		}
-		nDst += nd
+		// The rune is consumed even if its escape sequence does not
+		// fit in dst, the remainder is written by the next call.
+		n = copy(dst[nDst:], t.escaped[:nd])
+		nDst += n
 		nSrc += ns
+		if n < nd {
+			t.pending = t.escaped[n:nd]
+			return nDst, nSrc, transform.ErrShortDst
+		}
 	}
 }
 
@@ -223,7 +1077,10 @@ func escape(b []byte, r rune) {
 }
 
 // Reset implements encoding.Transformer.
-func (t jsonTransformer) Reset() {}
+func (t *jsonTransformer) Reset() {
+	t.pending = nil
+	t.e.Reset()
+}
 
 // A replacementError is the error type that will be implemented by an
 // encoding that doesn't include a particular rune.
@@ -231,7 +1088,89 @@ type replacementError interface {
 	Replacement() byte
 }
 
-func unmarshal(buf []byte, charset string, v interface{}) error {
+// charsetReader returns a reader that decodes the contents of r from the
+// given character set into UTF-8.
+func charsetReader(r io.Reader, charset string) (io.Reader, error) {
+	charset = strings.TrimSpace(charset)
+	if charset == "" || strings.EqualFold(charset, "utf-8") {
+		return r, nil
+	}
+	enc, err := ianaindex.MIME.Encoding(charset)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, errors.New("unmarshal: unsupported encoding")
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+// sniffCharset detects the character set of a JSON document that was
+// sent without one, returning "" if it is UTF-8.
+func sniffCharset(buf []byte) string {
+	if bytes.HasPrefix(buf, []byte{0xfe, 0xff}) || bytes.HasPrefix(buf, []byte{0xff, 0xfe}) {
+		// The byte order mark determines the endianness.
+		return "utf-16"
+	}
+	if len(buf) >= 2 {
+		// A JSON document starts with an ASCII character, which
+		// has a zero high byte when encoded as UTF-16.
+		switch {
+		case buf[0] == 0 && buf[1] != 0:
+			return "utf-16be"
+		case buf[0] != 0 && buf[1] == 0:
+			return "utf-16le"
+		}
+	}
+	if utf8.Valid(buf) {
+		return ""
+	}
+	return "windows-1252"
+}
+
+// maxSizeHint is the largest size hint for which readAll allocates a
+// buffer before reading, so that a false Content-Length cannot cause a
+// large allocation before any data has been received. Larger bodies
+// are read by growing the buffer as the data arrives.
+const maxSizeHint = 512 << 10
+
+// readAll reads from r until EOF. If sizeHint is greater than zero it is
+// the expected size of the data, which is used to read the data into a
+// single allocation rather than growing the buffer as it is read.
+func readAll(r io.Reader, sizeHint int64) ([]byte, error) {
+	if sizeHint <= 0 || sizeHint > maxSizeHint {
+		return io.ReadAll(r)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(sizeHint) + bytes.MinRead)
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// sizeHint returns the expected size of a message body with the given
+// header and content length once any Content-Encoding has been removed,
+// or -1 if it is not known. If max is greater than zero it is the
+// limit that will be applied to the body, a larger content length is
+// reduced to one more than max, which is enough to detect that the
+// limit has been exceeded.
+func sizeHint(h http.Header, contentLength, max int64) int64 {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding"))) {
+	case "", "identity":
+	default:
+		return -1
+	}
+	if max > 0 && contentLength > max+1 {
+		return max + 1
+	}
+	return contentLength
+}
+
+// unmarshal parses the JSON value in buf, encoded in the given character
+// set, and stores the result in v.
+func (o UnmarshalOptions) unmarshal(buf []byte, charset string, v interface{}) error {
+	if charset == "" && o.SniffCharset {
+		charset = sniffCharset(buf)
+	}
 	if charset != "" && !strings.EqualFold(charset, "utf-8") {
 		enc, err := ianaindex.MIME.Encoding(charset)
 		if err != nil {
@@ -245,5 +1184,58 @@ func unmarshal(buf []byte, charset string, v interface{}) error {
 			return err
 		}
 	}
-	return json.Unmarshal(buf, v)
+	return o.unmarshalJSON(buf, v)
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark.
+var utf8BOM = []byte("\ufeff")
+
+// trimBOM removes a byte order mark from the start of the UTF-8 encoded
+// buf. Some servers start JSON bodies with a byte order mark, which is
+// not valid JSON. A UTF-16 body with a byte order mark is decoded to
+// UTF-8 starting with a byte order mark, unless the charset is "utf-16"
+// in which case the byte order mark determines the endianness and is
+// removed when decoding.
+func trimBOM(buf []byte) []byte {
+	return bytes.TrimPrefix(buf, utf8BOM)
+}
+
+// unmarshalJSON parses the UTF-8 encoded JSON value in buf and stores the
+// result in v in the same way as json.Unmarshal, modified by the
+// DisallowUnknownFields and UseNumber options. A leading byte order mark
+// is ignored. An empty body results in ErrEmptyBody, unless
+// AllowEmptyBody is set. Syntax and type errors are returned as a
+// *DecodeError.
+func (o UnmarshalOptions) unmarshalJSON(buf []byte, v interface{}) error {
+	buf = trimBOM(buf)
+	if len(bytes.Trim(buf, " \t\r\n")) == 0 {
+		if o.AllowEmptyBody {
+			return nil
+		}
+		return ErrEmptyBody
+	}
+	return newDecodeError(buf, o.decodeJSON(buf, v))
+}
+
+// decodeJSON parses the JSON value in buf for unmarshalJSON.
+func (o UnmarshalOptions) decodeJSON(buf []byte, v interface{}) error {
+	if o.UnmarshalFunc != nil {
+		return o.UnmarshalFunc(buf, v)
+	}
+	if !o.DisallowUnknownFields && !o.UseNumber {
+		return json.Unmarshal(buf, v)
+	}
+	if !json.Valid(buf) {
+		// Report syntax errors, including trailing data, exactly as
+		// json.Unmarshal does.
+		return json.Unmarshal(buf, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	if o.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if o.UseNumber {
+		dec.UseNumber()
+	}
+	return dec.Decode(v)
 }
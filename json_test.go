@@ -1,16 +1,28 @@
 package httpjson_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
 
 	"github.com/mhilton/httpjson"
 )
@@ -25,6 +37,23 @@ var isJSONContentTypeTests = []struct {
 	{"text/json", true},
 	{"text/plain", false},
 	{`application/json;charset="ebcdic"`, true},
+	{"application/vnd.api+json", true},
+	{"application/ld+json", true},
+	{"application/json-patch+json", true},
+	{"application/problem+json; charset=utf-8", true},
+	{"  Application/VND.API+JSON ; charset=utf-8", true},
+	{"TEXT/JSON", true},
+	{"image/svg+json", true},
+	{"application/json-rpc", false},
+	{"application/jsonx", false},
+	{"application/json+", false},
+	{"application/vnd.api+json+xml", false},
+	{"+json", false},
+	{"vnd.api+json", false},
+	{"application /json", false},
+	{"charset=utf-8; application/json", true},
+	{"application/json; charset", true},
+	{"application/json, text/plain", false},
 }
 
 func TestIsJSONContentType(t *testing.T) {
@@ -35,6 +64,90 @@ func TestIsJSONContentType(t *testing.T) {
 	}
 }
 
+var strictIsJSONContentTypeTests = []struct {
+	contentType string
+	isJSON      bool
+}{
+	{"", false},
+	{"application/json", true},
+	{"application/something+json", true},
+	{"application/json;charset=utf-8", true},
+	{"text/json", false},
+	{"text/something+json", false},
+	{"text/plain", false},
+	{"application/jsonx", false},
+}
+
+func TestStrictIsJSONContentType(t *testing.T) {
+	for _, test := range strictIsJSONContentTypeTests {
+		if httpjson.StrictIsJSONContentType(test.contentType) != test.isJSON {
+			t.Errorf("StrictIsJSONContentType(%q) expected %v, got %v", test.contentType, test.isJSON, !test.isJSON)
+		}
+	}
+}
+
+var parseContentTypeTests = []struct {
+	contentType  string
+	expectType   string
+	expectParams map[string]string
+	expectError  string
+}{{
+	contentType:  "application/json; charset=utf-8",
+	expectType:   "application/json",
+	expectParams: map[string]string{"charset": "utf-8"},
+}, {
+	contentType:  "charset=utf-8; application/json",
+	expectType:   "application/json",
+	expectParams: map[string]string{"charset": "utf-8"},
+}, {
+	contentType:  `charset="ISO-8859-1" ; Application/JSON ; version=2`,
+	expectType:   "application/json",
+	expectParams: map[string]string{"charset": "ISO-8859-1", "version": "2"},
+}, {
+	contentType:  "application/json; charset",
+	expectType:   "application/json",
+	expectParams: map[string]string{},
+}, {
+	contentType:  "application/json; charset=utf 8; version=2",
+	expectType:   "application/json",
+	expectParams: map[string]string{"version": "2"},
+}, {
+	contentType:  "application/json;;charset=utf-8;",
+	expectType:   "application/json",
+	expectParams: map[string]string{"charset": "utf-8"},
+}, {
+	contentType: "",
+	expectError: `invalid Content-Type "": no media type`,
+}, {
+	contentType: "charset=utf-8",
+	expectError: `invalid Content-Type "charset=utf-8": no media type`,
+}, {
+	contentType: "application/json; text/plain",
+	expectError: `invalid Content-Type "application/json; text/plain": more than one media type`,
+}, {
+	contentType: "application/json, text/plain",
+	expectError: `invalid Content-Type "application/json, text/plain": mime: unexpected content after media subtype`,
+}, {
+	contentType: "charset=utf-8; application/json; charset=iso-8859-1",
+	expectError: `invalid Content-Type "charset=utf-8; application/json; charset=iso-8859-1": duplicate parameter "charset"`,
+}}
+
+func TestParseContentType(t *testing.T) {
+	for _, test := range parseContentTypeTests {
+		t.Run(test.contentType, func(t *testing.T) {
+			mt, params, err := httpjson.ParseContentType(test.contentType)
+			if test.expectError != "" {
+				qt.Assert(t, err, qt.Not(qt.IsNil))
+				qt.Check(t, err.Error(), qt.Equals, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, mt, qt.Equals, test.expectType)
+			qt.Check(t, params, qt.DeepEquals, test.expectParams)
+		})
+	}
+}
+
 var marshalRequestTests = []struct {
 	name              string
 	method            string
@@ -146,6 +259,232 @@ func TestMarshalRequestGetBody(t *testing.T) {
 	qt.Check(t, string(buf), qt.Equals, `{"s":"☺"}`)
 }
 
+type contextKey struct{}
+
+func TestMarshalRequestContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKey{}, "test")
+	for _, v := range []interface{}{nil, testValue{S: "☺"}, httpjson.RawBody{Reader: strings.NewReader(`{}`)}} {
+		req, err := httpjson.MarshalRequestContext(ctx, "POST", "https://test.example.com", "", v)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, req.Context(), qt.Equals, ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := httpjson.MarshalRequestContext(ctx, "POST", "https://test.example.com", "", testValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = http.DefaultClient.Do(req)
+	qt.Check(t, err, qt.ErrorIs, context.Canceled)
+
+	req, err = httpjson.MarshalRequest("POST", "https://test.example.com", "", testValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.Context(), qt.Equals, context.Background())
+}
+
+func TestMarshalRequestContentTyper(t *testing.T) {
+	req, err := httpjson.MarshalRequest("POST", "https://test.example.com", "", versionedValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, "application/vnd.test.v2+json;charset=us-ascii")
+	buf, err := io.ReadAll(req.Body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"\u263a"}`)
+
+	req, err = httpjson.MarshalRequest("POST", "https://test.example.com", "application/json;charset=utf-8", versionedValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+}
+
+// A readSeeker hides the concrete type of the io.ReadSeeker it holds.
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+// A readSeekerAt hides the concrete type of the *strings.Reader it
+// holds, while keeping its io.ReaderAt implementation.
+type readSeekerAt struct {
+	*strings.Reader
+}
+
+var marshalRequestRawBodyTests = []struct {
+	name                string
+	body                io.Reader
+	expectContentLength int64
+	expectContentType   string
+	expectBody          string
+	expectGetBody       bool
+}{{
+	name:                "bytes",
+	body:                bytes.NewReader([]byte("{\"s\":\"\xa3\"}")),
+	expectContentLength: 9,
+	expectContentType:   "application/json;charset=iso-8859-1",
+	expectBody:          "{\"s\":\"\xa3\"}",
+	expectGetBody:       true,
+}, {
+	name:                "string",
+	body:                strings.NewReader(`{"s": "☺"}`),
+	expectContentLength: 12,
+	expectContentType:   "application/json;charset=iso-8859-1",
+	expectBody:          `{"s": "☺"}`,
+	expectGetBody:       true,
+}, {
+	name:                "seeker",
+	body:                readSeeker{strings.NewReader(`[1, 2, 3]`)},
+	expectContentLength: 9,
+	expectContentType:   "application/json;charset=iso-8859-1",
+	expectBody:          `[1, 2, 3]`,
+}, {
+	name:                "reader_at",
+	body:                readSeekerAt{strings.NewReader(`[1, 2, 3]`)},
+	expectContentLength: 9,
+	expectContentType:   "application/json;charset=iso-8859-1",
+	expectBody:          `[1, 2, 3]`,
+	expectGetBody:       true,
+}, {
+	name:                "stream",
+	body:                iotest.OneByteReader(strings.NewReader(`[1, 2, 3]`)),
+	expectContentLength: 0,
+	expectContentType:   "application/json;charset=iso-8859-1",
+	expectBody:          `[1, 2, 3]`,
+}, {
+	name:          "empty",
+	body:          bytes.NewReader(nil),
+	expectGetBody: true,
+}}
+
+func TestMarshalRequestRawBody(t *testing.T) {
+	for _, test := range marshalRequestRawBodyTests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := httpjson.MarshalOptions{GzipMinBytes: 1}
+			req, err := opts.MarshalRequest("POST", "https://test.example.com", "application/json;charset=iso-8859-1", httpjson.RawBody{Reader: test.body})
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, req.ContentLength, qt.Equals, test.expectContentLength)
+			qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, req.Header.Get("Content-Encoding"), qt.Equals, "")
+			buf, err := io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+			if !test.expectGetBody {
+				qt.Check(t, req.GetBody, qt.IsNil)
+				return
+			}
+			qt.Assert(t, req.GetBody, qt.IsNotNil)
+			body, err := req.GetBody()
+			qt.Assert(t, err, qt.IsNil)
+			buf, err = io.ReadAll(body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestMarshalRequestRawBodyFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	qt.Assert(t, err, qt.IsNil)
+	defer f.Close()
+	_, err = f.WriteString(`xx{"s":"☺"}`)
+	qt.Assert(t, err, qt.IsNil)
+	// The body starts at the current offset of the file.
+	_, err = f.Seek(2, io.SeekStart)
+	qt.Assert(t, err, qt.IsNil)
+
+	req, err := httpjson.MarshalRequest("POST", "https://test.example.com", "", httpjson.RawBody{Reader: f})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.ContentLength, qt.Equals, int64(11))
+	qt.Assert(t, req.GetBody, qt.IsNotNil)
+
+	// Reading a body from GetBody before the request is sent must not
+	// consume the request's Body.
+	body, err := req.GetBody()
+	qt.Assert(t, err, qt.IsNil)
+	buf, err := io.ReadAll(body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"☺"}`)
+	buf, err = io.ReadAll(req.Body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"☺"}`)
+}
+
+func TestMarshal(t *testing.T) {
+	for _, test := range marshalRequestTests {
+		if test.v == nil || test.name == "invalid_url" {
+			continue
+		}
+		t.Run(test.name, func(t *testing.T) {
+			buf, err := httpjson.Marshal(test.contentType, test.v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, string(test.expectBody))
+		})
+	}
+}
+
+func TestMarshalContentTyper(t *testing.T) {
+	buf, err := httpjson.Marshal("", versionedValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"\u263a"}`)
+}
+
+var mergeContentTypeTests = []struct {
+	name        string
+	def         string
+	contentType string
+	expect      string
+}{{
+	name:   "no_content_type",
+	def:    "application/vnd.test+json;charset=iso-8859-1",
+	expect: "application/vnd.test+json;charset=iso-8859-1",
+}, {
+	name:        "no_default",
+	contentType: "application/json;charset=utf-8",
+	expect:      "application/json;charset=utf-8",
+}, {
+	name:        "charset_only",
+	def:         "application/vnd.test+json;charset=iso-8859-1",
+	contentType: ";charset=utf-8",
+	expect:      "application/vnd.test+json; charset=utf-8",
+}, {
+	name:        "parameters_only",
+	def:         "application/vnd.test+json;charset=iso-8859-1;version=1",
+	contentType: "; version=2",
+	expect:      "application/vnd.test+json; charset=iso-8859-1; version=2",
+}, {
+	name:        "media_type_only",
+	def:         "application/vnd.test+json;charset=iso-8859-1",
+	contentType: "application/merge-patch+json",
+	expect:      "application/merge-patch+json; charset=iso-8859-1",
+}, {
+	name:        "media_type_only_default_without_charset",
+	def:         "application/vnd.test+json",
+	contentType: "application/merge-patch+json",
+	expect:      "application/merge-patch+json",
+}, {
+	name:        "media_type_and_charset",
+	def:         "application/vnd.test+json;charset=iso-8859-1",
+	contentType: "application/merge-patch+json;charset=utf-8",
+	expect:      "application/merge-patch+json;charset=utf-8",
+}, {
+	name:        "invalid_default",
+	def:         "application/vnd.test+json;;",
+	contentType: ";charset=utf-8",
+	expect:      ";charset=utf-8",
+}, {
+	name:        "invalid_content_type",
+	def:         "application/vnd.test+json",
+	contentType: "application/json;charset",
+	expect:      "application/json;charset",
+}}
+
+func TestMergeContentType(t *testing.T) {
+	for _, test := range mergeContentTypeTests {
+		t.Run(test.name, func(t *testing.T) {
+			qt.Check(t, httpjson.MergeContentType(test.def, test.contentType), qt.Equals, test.expect)
+		})
+	}
+}
+
 var unmarshalRequestTests = []struct {
 	name        string
 	contentType string
@@ -186,7 +525,7 @@ var unmarshalRequestTests = []struct {
 	name:        "bad_json",
 	contentType: "application/json;charset=utf-8",
 	body:        strings.NewReader("{"),
-	expectError: `unexpected end of JSON input`,
+	expectError: `unexpected end of JSON input \(offset 1 near "{"\)`,
 }, {
 	name:        "read_error",
 	contentType: "application/json;charset=utf-8",
@@ -212,6 +551,53 @@ func TestUnmarshalRequest(t *testing.T) {
 	}
 }
 
+var unmarshalTests = []struct {
+	name        string
+	contentType string
+	buf         string
+	expectError string
+	expectValue interface{}
+}{{
+	name:        "utf-8",
+	contentType: "application/json;charset=utf-8",
+	buf:         `{"s":"☺"}`,
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "no_content_type",
+	buf:         `{"s":"☺"}`,
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	buf:         "{\"s\":\"\\u263a\xa3\"}",
+	expectValue: testValue{S: "☺£"},
+}, {
+	name:        "unknown_charset",
+	contentType: "application/json;charset=not-known",
+	buf:         `{"s":"☺"}`,
+	expectError: `ianaindex: invalid encoding name`,
+}, {
+	name:        "bad_json",
+	contentType: "application/json",
+	buf:         "{",
+	expectError: `unexpected end of JSON input \(offset 1 near "{"\)`,
+}}
+
+func TestUnmarshal(t *testing.T) {
+	for _, test := range unmarshalTests {
+		t.Run(test.name, func(t *testing.T) {
+			var v json.RawMessage
+			err := httpjson.Unmarshal([]byte(test.buf), test.contentType, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, []byte(v), qt.JSONEquals, test.expectValue)
+		})
+	}
+}
+
 var writeReponseTests = []struct {
 	name              string
 	code              int
@@ -284,75 +670,1511 @@ func TestWriteResponse(t *testing.T) {
 	}
 }
 
-var unmarshalResponseTests = []struct {
+func TestWriteResponseContentTyper(t *testing.T) {
+	rr := httptest.NewRecorder()
+	err := httpjson.WriteResponse(rr, http.StatusOK, "", versionedValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	resp := rr.Result()
+	qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/vnd.test.v2+json;charset=us-ascii")
+	body, err := io.ReadAll(resp.Body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(body), qt.Equals, `{"s":"\u263a"}`)
+}
+
+var plainIntegersTests = []struct {
+	name       string
+	v          interface{}
+	expectBody string
+}{{
+	name:       "large_float",
+	v:          []float64{1e21, -2.5e22, 1e-7, 1.5, 123456789},
+	expectBody: `[1000000000000000000000,-25000000000000000000000,1e-7,1.5,123456789]`,
+}, {
+	name:       "json_number",
+	v:          []json.Number{"1.5e3", "1E+2", "0.5e1", "12345678901234567890", "1.25e1", "0e10"},
+	expectBody: `[1500,100,5,12345678901234567890,1.25e1,0]`,
+}, {
+	name:       "strings_unchanged",
+	v:          map[string]interface{}{"1e21": "2e21 \"3e21\"", "n": 4e21},
+	expectBody: `{"1e21":"2e21 \"3e21\"","n":4000000000000000000000}`,
+}}
+
+func TestMarshalOptionsPlainIntegers(t *testing.T) {
+	opts := httpjson.MarshalOptions{PlainIntegers: true}
+	for _, test := range plainIntegersTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := opts.WriteResponse(rr, http.StatusOK, "", test.v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+		})
+	}
+}
+
+func TestMarshalOptionsPlainIntegersRoundTrip(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"f":1e+21,"n":12345678901234567890}`))
+	dec.UseNumber()
+	var v map[string]interface{}
+	err := dec.Decode(&v)
+	qt.Assert(t, err, qt.IsNil)
+	v["g"] = 2e21
+
+	opts := httpjson.MarshalOptions{PlainIntegers: true}
+	req, err := opts.MarshalRequest("PUT", "https://test.example.com", "application/json;charset=iso-8859-1", v)
+	qt.Assert(t, err, qt.IsNil)
+	body, err := io.ReadAll(req.Body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(body), qt.Equals, `{"f":1000000000000000000000,"g":2000000000000000000000,"n":12345678901234567890}`)
+}
+
+var indentTests = []struct {
 	name        string
+	opts        httpjson.MarshalOptions
 	contentType string
-	body        io.Reader
-	expectError string
-	expectValue interface{}
+	v           interface{}
+	expectBody  string
 }{{
-	name:        "utf-8",
-	contentType: "application/json;charset=utf-8",
-	body:        strings.NewReader(`{"s":"☺"}`),
-	expectValue: testValue{S: "☺"},
+	name:       "indent",
+	opts:       httpjson.MarshalOptions{Indent: "  "},
+	v:          map[string]interface{}{"a": []int{1, 2}, "s": "☺"},
+	expectBody: "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"s\": \"☺\"\n}",
 }, {
-	name:        "us-ascii",
-	contentType: "application/json;charset=us-ascii",
-	body:        strings.NewReader(`{"s":"\u263a"}`),
-	expectValue: testValue{S: "☺"},
+	name:       "prefix",
+	opts:       httpjson.MarshalOptions{Indent: "\t", IndentPrefix: "> "},
+	v:          testValue{S: "x"},
+	expectBody: "{\n> \t\"s\": \"x\"\n> }",
 }, {
-	name:        "iso-8859-1",
-	contentType: "application/json;charset=iso-8859-1",
-	body:        strings.NewReader("{\"s\":\"\\u263a\xa3\"}"),
-	expectValue: testValue{S: "☺£"},
+	name:       "prefix_without_indent",
+	opts:       httpjson.MarshalOptions{IndentPrefix: "> "},
+	v:          testValue{S: "x"},
+	expectBody: `{"s":"x"}`,
 }, {
-	name:        "unspecified_charset_utf-8",
+	name:        "us-ascii",
+	opts:        httpjson.MarshalOptions{Indent: " "},
 	contentType: "application/json",
-	body:        strings.NewReader(`{"s":"☺"}`),
-	expectValue: testValue{S: "☺"},
-}, {
-	name:        "unknown_charset",
-	contentType: "application/json;charset=not-known",
-	body:        strings.NewReader(`{"s":"☺"}`),
-	expectError: `ianaindex: invalid encoding name`,
-}, {
-	name:        "unsupported_charset",
-	contentType: "application/json;charset=OSD_EBCDIC_DF03_IRV",
-	body:        strings.NewReader(`{"s":"☺"}`),
-	expectError: `unmarshal: unsupported encoding`,
+	v:           []string{"☺", "😂"},
+	expectBody:  "[\n \"\\u263a\",\n \"\\ud83d\\ude02\"\n]",
 }, {
-	name:        "bad_json",
-	contentType: "application/json;charset=utf-8",
-	body:        strings.NewReader("{"),
-	expectError: `unexpected end of JSON input`,
+	name:        "iso-8859-1",
+	opts:        httpjson.MarshalOptions{Indent: " "},
+	contentType: "application/json;charset=iso-8859-1",
+	v:           testValue{S: "£☺"},
+	expectBody:  "{\n \"s\": \"\xa3\\u263a\"\n}",
 }, {
-	name:        "read_error",
-	contentType: "application/json;charset=utf-8",
-	body:        iotest.ErrReader(errors.New("test error")),
-	expectError: `test error`,
+	name:       "plain_integers",
+	opts:       httpjson.MarshalOptions{Indent: " ", PlainIntegers: true},
+	v:          []float64{1e21},
+	expectBody: "[\n 1000000000000000000000\n]",
 }}
 
-func TestUnmarshalResponse(t *testing.T) {
-	for _, test := range unmarshalResponseTests {
+func TestMarshalOptionsIndent(t *testing.T) {
+	for _, test := range indentTests {
 		t.Run(test.name, func(t *testing.T) {
-			resp := &http.Response{
-				Header: http.Header{
-					"Content-Type": []string{test.contentType},
-				},
-				Body: io.NopCloser(test.body),
-			}
-			var v json.RawMessage
-			err := httpjson.UnmarshalResponse(resp, &v)
-			if test.expectError != "" {
-				qt.Check(t, err, qt.ErrorMatches, test.expectError)
-				return
-			}
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, test.v)
 			qt.Assert(t, err, qt.IsNil)
-			qt.Check(t, []byte(v), qt.JSONEquals, test.expectValue)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
 		})
 	}
 }
 
-type testValue struct {
-	S string `json:"s"`
+var disableHTMLEscapeTests = []struct {
+	name        string
+	opts        httpjson.MarshalOptions
+	contentType string
+	v           interface{}
+	expectBody  string
+}{{
+	name:       "default",
+	v:          testValue{S: "<a & b>"},
+	expectBody: `{"s":"\u003ca \u0026 b\u003e"}`,
+}, {
+	name:       "utf-8",
+	opts:       httpjson.MarshalOptions{DisableHTMLEscape: true},
+	v:          testValue{S: "<a & ☺>"},
+	expectBody: `{"s":"<a & ☺>"}`,
+}, {
+	name:        "us-ascii",
+	opts:        httpjson.MarshalOptions{DisableHTMLEscape: true},
+	contentType: "application/json",
+	v:           testValue{S: "<a & ☺ 😂>"},
+	expectBody:  `{"s":"<a & \u263a \ud83d\ude02>"}`,
+}, {
+	name:        "iso-8859-1",
+	opts:        httpjson.MarshalOptions{DisableHTMLEscape: true},
+	contentType: "application/json;charset=iso-8859-1",
+	v:           testValue{S: "<£ & ☺>"},
+	expectBody:  "{\"s\":\"<\xa3 & \\u263a>\"}",
+}, {
+	name:        "line_separator",
+	opts:        httpjson.MarshalOptions{DisableHTMLEscape: true},
+	contentType: "application/json",
+	v:           testValue{S: "a\u2028b"},
+	expectBody:  `{"s":"a\u2028b"}`,
+}, {
+	name:       "indent",
+	opts:       httpjson.MarshalOptions{DisableHTMLEscape: true, Indent: " "},
+	v:          []string{"<>"},
+	expectBody: "[\n \"<>\"\n]",
+}}
+
+func TestMarshalOptionsDisableHTMLEscape(t *testing.T) {
+	for _, test := range disableHTMLEscapeTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+
+			req, err := test.opts.MarshalRequest("POST", "https://test.example.com", test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			body, err = io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+var escapeNonASCIITests = []struct {
+	name        string
+	opts        httpjson.MarshalOptions
+	contentType string
+	v           interface{}
+	expectBody  string
+}{{
+	name:       "utf-8",
+	v:          testValue{S: "<☺ 😂>"},
+	expectBody: `{"s":"\u003c☺ 😂\u003e"}`,
+}, {
+	name:       "utf-8_escaped",
+	opts:       httpjson.MarshalOptions{EscapeNonASCII: true},
+	v:          testValue{S: "<☺ 😂>"},
+	expectBody: `{"s":"\u003c\u263a \ud83d\ude02\u003e"}`,
+}, {
+	name:       "disable_html_escape",
+	opts:       httpjson.MarshalOptions{EscapeNonASCII: true, DisableHTMLEscape: true},
+	v:          testValue{S: "<☺ 😂>"},
+	expectBody: `{"s":"<\u263a \ud83d\ude02>"}`,
+}, {
+	name:        "utf-8_without_charset",
+	opts:        httpjson.MarshalOptions{EscapeNonASCII: true, DefaultCharset: "utf-8"},
+	contentType: "application/json",
+	v:           testValue{S: "😂"},
+	expectBody:  `{"s":"\ud83d\ude02"}`,
+}, {
+	name:        "iso-8859-1",
+	opts:        httpjson.MarshalOptions{EscapeNonASCII: true},
+	contentType: "application/json;charset=iso-8859-1",
+	v:           testValue{S: "£ 😂"},
+	expectBody:  "{\"s\":\"\xa3 \\ud83d\\ude02\"}",
+}, {
+	name:       "indent",
+	opts:       httpjson.MarshalOptions{EscapeNonASCII: true, Indent: " "},
+	v:          []string{"😂"},
+	expectBody: "[\n \"\\ud83d\\ude02\"\n]",
+}}
+
+func TestMarshalOptionsEscapeNonASCII(t *testing.T) {
+	for _, test := range escapeNonASCIITests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+
+			req, err := test.opts.MarshalRequest("POST", "https://test.example.com", test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			body, err = io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestMarshalUTF8MatchesJSONMarshal(t *testing.T) {
+	values := []interface{}{
+		testValue{S: "<a & b>"},
+		testValue{S: "☺ 😂 \u2028 \u2029"},
+		testValue{S: "invalid \xff utf-8"},
+		map[string]interface{}{"😂": []interface{}{1.5, nil, true, "𝄞"}},
+	}
+	for _, v := range values {
+		expect, err := json.Marshal(v)
+		qt.Assert(t, err, qt.IsNil)
+		body, err := httpjson.Marshal("application/json;charset=utf-8", v)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, body, qt.DeepEquals, expect)
+	}
+}
+
+var rawMessageTests = []struct {
+	name        string
+	opts        httpjson.MarshalOptions
+	contentType string
+	v           json.RawMessage
+	expectError string
+	expectBody  string
+}{{
+	name:       "utf-8",
+	v:          json.RawMessage(" {\"s\": \"<☺>\",\n \"n\": 1e21}\n"),
+	expectBody: "{\"s\": \"<☺>\",\n \"n\": 1e21}",
+}, {
+	name:        "us-ascii",
+	contentType: "application/json",
+	v:           json.RawMessage(`{"s": "☺ 😂"}`),
+	expectBody:  `{"s": "\u263a \ud83d\ude02"}`,
+}, {
+	name:        "iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	v:           json.RawMessage(`["£", "☺"]`),
+	expectBody:  "[\"\xa3\", \"\\u263a\"]",
+}, {
+	name:       "indent",
+	opts:       httpjson.MarshalOptions{Indent: "  "},
+	v:          json.RawMessage(`{"a":[1, 2]}`),
+	expectBody: "{\n  \"a\": [\n    1,\n    2\n  ]\n}",
+}, {
+	name:       "plain_integers",
+	opts:       httpjson.MarshalOptions{PlainIntegers: true},
+	v:          json.RawMessage(`[1e21]`),
+	expectBody: `[1000000000000000000000]`,
+}, {
+	name:        "invalid",
+	v:           json.RawMessage(`{"s":`),
+	expectError: `json: error calling MarshalJSON for type .*: unexpected end of JSON input`,
+}}
+
+func TestWriteResponseRawMessage(t *testing.T) {
+	for _, test := range rawMessageTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, test.v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				qt.Check(t, rr.Body.Len(), qt.Equals, 0)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+		})
+	}
+}
+
+func BenchmarkWriteResponseRawMessage(b *testing.B) {
+	v := json.RawMessage(`{"items":[` + strings.Repeat(`{"s":"<value>","n":12345},`, 100) + `{}]}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := httpjson.WriteResponse(httptest.NewRecorder(), http.StatusOK, "", v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// A discardResponseWriter is an http.ResponseWriter that discards the
+// response, for use in benchmarks.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+func BenchmarkWriteResponse(b *testing.B) {
+	benchmark := func(b *testing.B, contentType string) {
+		w := &discardResponseWriter{header: make(http.Header)}
+		v := testValue{S: "test message £"}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := httpjson.WriteResponse(w, http.StatusOK, contentType, v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("utf-8", func(b *testing.B) {
+		benchmark(b, "application/json;charset=utf-8")
+	})
+	b.Run("iso-8859-1", func(b *testing.B) {
+		benchmark(b, "application/json;charset=iso-8859-1")
+	})
+}
+
+var defaultCharsetTests = []struct {
+	name        string
+	opts        httpjson.MarshalOptions
+	contentType string
+	expectBody  string
+}{{
+	name:        "us-ascii",
+	contentType: "application/json",
+	expectBody:  `{"s":"\u00a3\u263a"}`,
+}, {
+	name:        "utf-8",
+	opts:        httpjson.MarshalOptions{DefaultCharset: "utf-8"},
+	contentType: "application/json",
+	expectBody:  `{"s":"£☺"}`,
+}, {
+	name:        "iso-8859-1",
+	opts:        httpjson.MarshalOptions{DefaultCharset: "iso-8859-1"},
+	contentType: "application/vnd.test+json",
+	expectBody:  "{\"s\":\"\xa3\\u263a\"}",
+}, {
+	name:        "explicit_charset",
+	opts:        httpjson.MarshalOptions{DefaultCharset: "utf-8"},
+	contentType: "application/json;charset=us-ascii",
+	expectBody:  `{"s":"\u00a3\u263a"}`,
+}}
+
+func TestMarshalOptionsDefaultCharset(t *testing.T) {
+	v := testValue{S: "£☺"}
+	for _, test := range defaultCharsetTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.contentType)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+
+			req, err := test.opts.MarshalRequest("POST", "https://test.example.com", test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			body, err = io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, test.contentType)
+
+			body, err = test.opts.Marshal(test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+var defaultContentTypeTests = []struct {
+	name              string
+	opts              httpjson.MarshalOptions
+	contentType       string
+	v                 interface{}
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "default",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "options",
+	opts:              httpjson.MarshalOptions{DefaultContentType: "application/json", DefaultCharset: "utf-8"},
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "options_us-ascii",
+	opts:              httpjson.MarshalOptions{DefaultContentType: "application/vnd.test+json"},
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/vnd.test+json",
+	expectBody:        `{"s":"\u263a"}`,
+}, {
+	name:              "content_typer",
+	opts:              httpjson.MarshalOptions{DefaultContentType: "application/vnd.test+json;charset=utf-8"},
+	v:                 latin1Value{S: "£"},
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}",
+}, {
+	name:              "call",
+	opts:              httpjson.MarshalOptions{DefaultContentType: "application/json;charset=us-ascii"},
+	contentType:       "application/json;charset=iso-8859-1",
+	v:                 versionedValue{S: "£"},
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}",
+}}
+
+func TestMarshalOptionsDefaultContentType(t *testing.T) {
+	for _, test := range defaultContentTypeTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := test.opts.WriteResponse(rr, http.StatusOK, test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, rr.Header().Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, rr.Body.String(), qt.Equals, test.expectBody)
+
+			req, err := test.opts.MarshalRequest("POST", "https://test.example.com", test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			body, err := io.ReadAll(req.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+
+			body, err = test.opts.Marshal(test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+var unmarshalResponseTests = []struct {
+	name        string
+	contentType string
+	body        io.Reader
+	expectError string
+	expectValue interface{}
+}{{
+	name:        "utf-8",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader(`{"s":"☺"}`),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "us-ascii",
+	contentType: "application/json;charset=us-ascii",
+	body:        strings.NewReader(`{"s":"\u263a"}`),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        strings.NewReader("{\"s\":\"\\u263a\xa3\"}"),
+	expectValue: testValue{S: "☺£"},
+}, {
+	name:        "unspecified_charset_utf-8",
+	contentType: "application/json",
+	body:        strings.NewReader(`{"s":"☺"}`),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "unknown_charset",
+	contentType: "application/json;charset=not-known",
+	body:        strings.NewReader(`{"s":"☺"}`),
+	expectError: `ianaindex: invalid encoding name`,
+}, {
+	name:        "unsupported_charset",
+	contentType: "application/json;charset=OSD_EBCDIC_DF03_IRV",
+	body:        strings.NewReader(`{"s":"☺"}`),
+	expectError: `unmarshal: unsupported encoding`,
+}, {
+	name:        "bad_json",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader("{"),
+	expectError: `unexpected end of JSON input \(offset 1 near "{"\)`,
+}, {
+	name:        "empty",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader(""),
+	expectError: `empty body`,
+}, {
+	name:        "trailing_space",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader("{\"s\":\"☺\"}\r\n \t\n"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "trailing_data",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader(`{"s":"☺"} {"s":"☺"}`),
+	expectError: `invalid character '{' after top-level value \(offset 13 near "{\\"s\\":\\"☺\\"} {\\"s\\":\\"☺\\"}"\)`,
+}, {
+	name:        "trailing_data_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        strings.NewReader(`{"s":"a"}x`),
+	expectError: `invalid character 'x' after top-level value \(offset 10 near "{\\"s\\":\\"a\\"}x"\)`,
+}, {
+	name:        "utf-8_bom",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader("\xef\xbb\xbf{\"s\":\"☺\"}"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "unspecified_charset_bom",
+	contentType: "application/json",
+	body:        strings.NewReader("\xef\xbb\xbf{\"s\":\"☺\"}"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16le_bom",
+	contentType: "application/json;charset=utf-16le",
+	body:        strings.NewReader("\xff\xfe{\x00\"\x00s\x00\"\x00:\x00\"\x00\x3a\x26\"\x00}\x00"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16be_bom",
+	contentType: "application/json;charset=utf-16be",
+	body:        strings.NewReader("\xfe\xff\x00{\x00\"\x00s\x00\"\x00:\x00\"\x26\x3a\x00\"\x00}"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16_little_endian_bom",
+	contentType: "application/json;charset=utf-16",
+	body:        strings.NewReader("\xff\xfe{\x00\"\x00s\x00\"\x00:\x00\"\x00\x3a\x26\"\x00}\x00"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16_big_endian_bom",
+	contentType: "application/json;charset=utf-16",
+	body:        strings.NewReader("\xfe\xff\x00{\x00\"\x00s\x00\"\x00:\x00\"\x26\x3a\x00\"\x00}"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16_no_bom",
+	contentType: "application/json;charset=utf-16",
+	body:        strings.NewReader("\x00{\x00\"\x00s\x00\"\x00:\x00\"\x26\x3a\x00\"\x00}"),
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "bom_only",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader("\xef\xbb\xbf"),
+	expectError: `empty body`,
+}, {
+	name:        "white_space_only",
+	contentType: "application/json;charset=utf-8",
+	body:        strings.NewReader(" \r\n\t "),
+	expectError: `empty body`,
+}, {
+	name:        "read_error",
+	contentType: "application/json;charset=utf-8",
+	body:        iotest.ErrReader(errors.New("test error")),
+	expectError: `test error`,
+}, {
+	name:        "truncated_read_error",
+	contentType: "application/json;charset=utf-8",
+	body:        io.MultiReader(strings.NewReader(`{"s":`), iotest.ErrReader(io.ErrUnexpectedEOF)),
+	expectError: `unexpected EOF`,
+}}
+
+var emptyBodyTests = []struct {
+	name string
+	body string
+}{{
+	name: "empty",
+	body: "",
+}, {
+	name: "white_space",
+	body: " \r\n\t ",
+}, {
+	name: "bom",
+	body: "\ufeff\n",
+}}
+
+func TestUnmarshalResponseEmptyBody(t *testing.T) {
+	for _, test := range emptyBodyTests {
+		t.Run(test.name, func(t *testing.T) {
+			newResponse := func() *http.Response {
+				return &http.Response{
+					Header: http.Header{
+						"Content-Type": []string{"application/json;charset=utf-8"},
+					},
+					Body: io.NopCloser(strings.NewReader(test.body)),
+				}
+			}
+			v := testValue{S: "unchanged"}
+			err := httpjson.UnmarshalResponse(newResponse(), &v)
+			qt.Check(t, err, qt.ErrorIs, httpjson.ErrEmptyBody)
+
+			err = httpjson.UnmarshalOptions{AllowEmptyBody: true}.UnmarshalResponse(newResponse(), &v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v.S, qt.Equals, "unchanged")
+		})
+	}
+}
+
+func TestUnmarshalResponse(t *testing.T) {
+	for _, test := range unmarshalResponseTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type": []string{test.contentType},
+				},
+				Body: io.NopCloser(test.body),
+			}
+			var v json.RawMessage
+			err := httpjson.UnmarshalResponse(resp, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, []byte(v), qt.JSONEquals, test.expectValue)
+		})
+	}
+}
+
+func TestUnmarshalResponseContentLength(t *testing.T) {
+	// The Content-Length is only used as a hint, so an incorrect value
+	// doesn't affect the result.
+	body := `{"s":"` + strings.Repeat("☺", 1000) + `"}`
+	for _, n := range []int64{-1, 0, 10, int64(len(body)), 100000, 1 << 40} {
+		resp := &http.Response{
+			Header:        http.Header{"Content-Type": {"application/json;charset=utf-8"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: n,
+		}
+		var v testValue
+		err := httpjson.UnmarshalResponse(resp, &v)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, v.S, qt.Equals, strings.Repeat("☺", 1000))
+	}
+}
+
+var disallowUnknownFieldsTests = []struct {
+	name        string
+	contentType string
+	body        string
+	expectError string
+	expectValue testValue
+}{{
+	name:        "known_fields",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"s":"☺"}`,
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "unknown_field",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"s":"☺","x":1}`,
+	expectError: `json: unknown field "x"`,
+}, {
+	name:        "unknown_field_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        "{\"s\":\"a\",\"\xa3\":1}",
+	expectError: `json: unknown field "£"`,
+}, {
+	name:        "trailing_data",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"s":"☺"} {}`,
+	expectError: `invalid character '{' after top-level value \(offset 13 near "{\\"s\\":\\"☺\\"} {}"\)`,
+}, {
+	name:        "empty",
+	contentType: "application/json;charset=utf-8",
+	body:        ``,
+	expectError: `empty body`,
+}}
+
+func TestUnmarshalOptionsDisallowUnknownFields(t *testing.T) {
+	opts := httpjson.UnmarshalOptions{DisallowUnknownFields: true}
+	for _, test := range disallowUnknownFieldsTests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(test.body))
+			qt.Assert(t, err, qt.IsNil)
+			req.Header.Set("Content-Type", test.contentType)
+			resp := &http.Response{
+				Header: http.Header{"Content-Type": {test.contentType}},
+				Body:   io.NopCloser(strings.NewReader(test.body)),
+			}
+			var reqv, respv testValue
+			reqErr := opts.UnmarshalRequest(req, &reqv)
+			respErr := opts.UnmarshalResponse(resp, &respv)
+			if test.expectError != "" {
+				qt.Check(t, reqErr, qt.ErrorMatches, test.expectError)
+				qt.Check(t, respErr, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Check(t, reqErr, qt.IsNil)
+			qt.Check(t, respErr, qt.IsNil)
+			qt.Check(t, reqv, qt.Equals, test.expectValue)
+			qt.Check(t, respv, qt.Equals, test.expectValue)
+		})
+	}
+}
+
+func TestUnmarshalOptionsUseNumber(t *testing.T) {
+	const body = `{"n":1234567890123456789,"f":1.5,"a":[9007199254740993]}`
+	for _, opts := range []httpjson.UnmarshalOptions{
+		{UseNumber: true},
+		{UseNumber: true, DisallowUnknownFields: true},
+	} {
+		req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(body))
+		qt.Assert(t, err, qt.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+		var reqv map[string]interface{}
+		err = opts.UnmarshalRequest(req, &reqv)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, reqv, qt.DeepEquals, map[string]interface{}{
+			"n": json.Number("1234567890123456789"),
+			"f": json.Number("1.5"),
+			"a": []interface{}{json.Number("9007199254740993")},
+		})
+
+		resp := &http.Response{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   io.NopCloser(strings.NewReader(body)),
+		}
+		var respv map[string]interface{}
+		err = opts.UnmarshalResponse(resp, &respv)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, respv, qt.DeepEquals, reqv)
+
+		// The exact value survives being encoded again.
+		buf, err := httpjson.Marshal("", respv)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(buf), qt.Equals, `{"a":[9007199254740993],"f":1.5,"n":1234567890123456789}`)
+	}
+
+	var v map[string]interface{}
+	err := httpjson.Unmarshal([]byte(body), "", &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v["n"], qt.Equals, float64(1234567890123456789))
+}
+
+func TestUnmarshalOptionsUnmarshalResponseMaxBodyBytes(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   io.NopCloser(strings.NewReader(`{"s":"` + strings.Repeat("x", 100) + `"}`)),
+	}
+	var v testValue
+	err := httpjson.UnmarshalOptions{MaxBodyBytes: 100}.UnmarshalResponse(resp, &v)
+	qt.Check(t, err, qt.Equals, httpjson.ErrResponseTooLarge)
+}
+
+var requireJSONContentTypeTests = []struct {
+	name        string
+	contentType string
+	expectError string
+}{{
+	name:        "json",
+	contentType: "application/json;charset=utf-8",
+}, {
+	name:        "json_suffix",
+	contentType: "application/vnd.test+json",
+}, {
+	name:        "form",
+	contentType: "application/x-www-form-urlencoded",
+	expectError: `unsupported Content-Type "application/x-www-form-urlencoded"`,
+}, {
+	name:        "missing",
+	expectError: `unsupported Content-Type ""`,
+}}
+
+func TestUnmarshalOptionsRequireJSONContentType(t *testing.T) {
+	opts := httpjson.UnmarshalOptions{RequireJSONContentType: true}
+	for _, test := range requireJSONContentTypeTests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://test.example.com", strings.NewReader(`{"s":"☺"}`))
+			qt.Assert(t, err, qt.IsNil)
+			req.Header.Set("Content-Type", test.contentType)
+			var v testValue
+			err = opts.UnmarshalRequest(req, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				qt.Check(t, err, qt.ErrorIs, httpjson.ErrUnsupportedMediaType)
+			} else {
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, v.S, qt.Equals, "☺")
+			}
+
+			resp := &http.Response{
+				Header: http.Header{"Content-Type": {test.contentType}},
+				Body:   io.NopCloser(strings.NewReader(`{"s":"☺"}`)),
+			}
+			v = testValue{}
+			err = opts.UnmarshalResponse(resp, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				qt.Check(t, err, qt.ErrorIs, httpjson.ErrUnsupportedMediaType)
+			} else {
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, v.S, qt.Equals, "☺")
+			}
+		})
+	}
+}
+
+var falseContentLengthTests = []struct {
+	name         string
+	maxBodyBytes int64
+}{{
+	name:         "limit",
+	maxBodyBytes: 1024,
+}, {
+	name: "no_limit",
+}}
+
+func TestUnmarshalFalseContentLength(t *testing.T) {
+	for _, test := range falseContentLengthTests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := httpjson.UnmarshalOptions{MaxBodyBytes: test.maxBodyBytes}
+			req := httptest.NewRequest("POST", "/", strings.NewReader(`{"s":"a"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.ContentLength = 60 << 20
+			resp := &http.Response{
+				Header:        http.Header{"Content-Type": {"application/json"}},
+				Body:          io.NopCloser(strings.NewReader(`{"s":"a"}`)),
+				ContentLength: 60 << 20,
+			}
+			var ms0, ms1 runtime.MemStats
+			runtime.ReadMemStats(&ms0)
+			var v1, v2 testValue
+			err1 := opts.UnmarshalRequest(req, &v1)
+			err2 := opts.UnmarshalResponse(resp, &v2)
+			runtime.ReadMemStats(&ms1)
+			qt.Assert(t, err1, qt.IsNil)
+			qt.Assert(t, err2, qt.IsNil)
+			qt.Check(t, v1, qt.Equals, testValue{S: "a"})
+			qt.Check(t, v2, qt.Equals, testValue{S: "a"})
+			// The Content-Length must not cause a buffer of that size
+			// to be allocated.
+			qt.Check(t, ms1.TotalAlloc-ms0.TotalAlloc < 1<<20, qt.IsTrue, qt.Commentf("allocated %d bytes", ms1.TotalAlloc-ms0.TotalAlloc))
+		})
+	}
+}
+
+func TestUnmarshalRequestLenientContentType(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://test.example.com", strings.NewReader(`{"s":"☺"}`))
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Content-Type", "text/plain")
+	var v testValue
+	err = httpjson.UnmarshalRequest(req, &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v.S, qt.Equals, "☺")
+}
+
+var duplicateCharsetTests = []struct {
+	name        string
+	contentType string
+	body        string
+	expectError string
+	expectValue testValue
+}{{
+	name:        "contradictory",
+	contentType: "application/json; charset=utf-8; charset=iso-8859-1",
+	body:        "{\"s\":\"\xa3\"}",
+	expectError: `invalid Content-Type "application/json; charset=utf-8; charset=iso-8859-1": duplicate charset parameter`,
+}, {
+	name:        "contradictory_case",
+	contentType: "application/json;charset=iso-8859-1;CHARSET=utf-8",
+	body:        `{"s":"☺"}`,
+	expectError: `invalid Content-Type "application/json;charset=iso-8859-1;CHARSET=utf-8": duplicate charset parameter`,
+}, {
+	name:        "same_value_case",
+	contentType: `application/json; charset=iso-8859-1; charset="ISO-8859-1"`,
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "£"},
+}, {
+	name:        "same_value",
+	contentType: `application/json; charset=iso-8859-1; charset="iso-8859-1"`,
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "£"},
+}, {
+	name:        "other_parameter",
+	contentType: "application/json; charset=iso-8859-1; v=1; v=2",
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "£"},
+}, {
+	name:        "malformed_parameter",
+	contentType: "application/json; charset=iso-8859-1; v",
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "£"},
+}}
+
+func TestUnmarshalDuplicateCharset(t *testing.T) {
+	for _, test := range duplicateCharsetTests {
+		t.Run(test.name, func(t *testing.T) {
+			check := func(err error, v testValue, errorPrefix string) {
+				t.Helper()
+				if test.expectError != "" {
+					qt.Check(t, err, qt.ErrorMatches, errorPrefix+test.expectError)
+					qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+					return
+				}
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, v, qt.DeepEquals, test.expectValue)
+			}
+
+			req, err := http.NewRequest("POST", "https://test.example.com", strings.NewReader(test.body))
+			qt.Assert(t, err, qt.IsNil)
+			req.Header.Set("Content-Type", test.contentType)
+			var v testValue
+			check(httpjson.UnmarshalRequest(req, &v), v, "")
+
+			resp := &http.Response{
+				Header: http.Header{"Content-Type": {test.contentType}},
+				Body:   io.NopCloser(strings.NewReader(test.body)),
+			}
+			v = testValue{}
+			check(httpjson.UnmarshalResponse(resp, &v), v, "")
+
+			v = testValue{}
+			check(httpjson.Unmarshal([]byte(test.body), test.contentType, &v), v, "")
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+			// Accept the malformed Content-Type so that the body is decoded.
+			cl := httpjson.Client{
+				IsJSONContentType: func(string) bool { return true },
+			}
+			v = testValue{}
+			check(cl.Get(context.Background(), srv.URL, &v), v, `GET http://.*: `)
+		})
+	}
+}
+
+func TestMarshalDuplicateCharset(t *testing.T) {
+	for _, test := range duplicateCharsetTests {
+		t.Run(test.name, func(t *testing.T) {
+			v := test.expectValue
+			if test.expectError != "" {
+				v = testValue{S: "£"}
+			}
+			check := func(body []byte, err error) {
+				t.Helper()
+				if test.expectError != "" {
+					qt.Check(t, err, qt.ErrorMatches, `(.*: )?`+regexp.QuoteMeta(test.expectError))
+					qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+					return
+				}
+				qt.Assert(t, err, qt.IsNil)
+				var got testValue
+				err = httpjson.Unmarshal(body, test.contentType, &got)
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, got, qt.DeepEquals, v)
+			}
+
+			check(httpjson.Marshal(test.contentType, v))
+
+			req, err := httpjson.MarshalRequest("POST", "https://test.example.com", test.contentType, v)
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(req.Body)
+			}
+			check(body, err)
+
+			req, err = httpjson.MarshalRequestStream("POST", "https://test.example.com", test.contentType, v)
+			body = nil
+			if err == nil {
+				body, err = io.ReadAll(req.Body)
+			}
+			check(body, err)
+
+			rr := httptest.NewRecorder()
+			err = httpjson.WriteResponse(rr, http.StatusOK, test.contentType, v)
+			check(rr.Body.Bytes(), err)
+
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/", nil)
+			err = httpjson.WriteResponseCompressed(rr, req, http.StatusOK, test.contentType, v)
+			check(rr.Body.Bytes(), err)
+
+			rr = httptest.NewRecorder()
+			err = httpjson.NewResponseStream(rr, test.contentType).Encode(v)
+			check(rr.Body.Bytes(), err)
+
+			rr = httptest.NewRecorder()
+			httpjson.ResponseHandler(http.StatusOK, test.contentType, v).ServeHTTP(rr, req)
+			if test.expectError != "" {
+				qt.Check(t, rr.Code, qt.Equals, http.StatusInternalServerError)
+			} else {
+				qt.Check(t, rr.Code, qt.Equals, http.StatusOK)
+				check(rr.Body.Bytes(), nil)
+			}
+
+			srv := httptest.NewServer(echoHandler)
+			defer srv.Close()
+			for _, pool := range []bool{false, true} {
+				cl := httpjson.Client{
+					PoolRequestBodies: pool,
+					IsJSONContentType: func(string) bool { return true },
+				}
+				var resp testValue
+				err := cl.Do(context.Background(), "POST", srv.URL, test.contentType, v, &resp)
+				if test.expectError != "" {
+					check(nil, err)
+					continue
+				}
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, resp, qt.DeepEquals, v)
+			}
+		})
+	}
+}
+
+var charsetParameterTests = []struct {
+	name        string
+	contentType string
+	body        string
+}{{
+	name:        "quoted",
+	contentType: `application/json; charset="UTF-8"`,
+	body:        `{"s":"£☺"}`,
+}, {
+	name:        "mixed_case_name",
+	contentType: `application/json; Charset=utf-8`,
+	body:        `{"s":"£☺"}`,
+}, {
+	name:        "upper_case_value",
+	contentType: `application/json; charset=ISO-8859-1`,
+	body:        "{\"s\":\"\xa3\\u263a\"}",
+}, {
+	name:        "quoted_upper_case",
+	contentType: `application/json; CHARSET="ISO-8859-1"`,
+	body:        "{\"s\":\"\xa3\\u263a\"}",
+}, {
+	name:        "white_space",
+	contentType: `application/json ; charset = iso-8859-1 `,
+	body:        "{\"s\":\"\xa3\\u263a\"}",
+}, {
+	name:        "quoted_white_space",
+	contentType: `application/json; charset=" utf-8 "`,
+	body:        `{"s":"£☺"}`,
+}}
+
+func TestCharsetParameter(t *testing.T) {
+	v := testValue{S: "£☺"}
+	for _, test := range charsetParameterTests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := httpjson.Marshal(test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.body)
+
+			req, err := httpjson.MarshalRequest("POST", "https://test.example.com", test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			var got testValue
+			err = httpjson.UnmarshalRequest(req, &got)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.DeepEquals, v)
+
+			rr := httptest.NewRecorder()
+			err = httpjson.WriteResponse(rr, http.StatusOK, test.contentType, v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, rr.Body.String(), qt.Equals, test.body)
+			got = testValue{}
+			err = httpjson.UnmarshalResponse(rr.Result(), &got)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.DeepEquals, v)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+			got = testValue{}
+			err = httpjson.Get(context.Background(), srv.URL, &got)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.DeepEquals, v)
+		})
+	}
+}
+
+var unmarshalResponsePrimitiveTests = []struct {
+	name        string
+	contentType string
+	body        string
+	v           interface{}
+	expectValue interface{}
+}{{
+	name:        "string_utf-8",
+	contentType: "application/json;charset=utf-8",
+	body:        `"ok ☺"`,
+	v:           new(string),
+	expectValue: "ok ☺",
+}, {
+	name:        "string_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        "\"ok \xa3\"",
+	v:           new(string),
+	expectValue: "ok £",
+}, {
+	name:        "string_escaped_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        `"\u263a"`,
+	v:           new(string),
+	expectValue: "☺",
+}, {
+	name:        "number_utf-8",
+	contentType: "application/json;charset=utf-8",
+	body:        `42`,
+	v:           new(int),
+	expectValue: 42,
+}, {
+	name:        "number_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        ` -1.5e3 `,
+	v:           new(float64),
+	expectValue: -1500.0,
+}, {
+	name:        "bool_utf-8",
+	contentType: "application/json;charset=utf-8",
+	body:        `true`,
+	v:           new(bool),
+	expectValue: true,
+}, {
+	name:        "bool_iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        `false`,
+	v:           new(bool),
+	expectValue: false,
+}}
+
+func TestUnmarshalResponsePrimitive(t *testing.T) {
+	for _, test := range unmarshalResponsePrimitiveTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type": []string{test.contentType},
+				},
+				Body: io.NopCloser(strings.NewReader(test.body)),
+			}
+			err := httpjson.UnmarshalResponse(resp, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, reflect.ValueOf(test.v).Elem().Interface(), qt.Equals, test.expectValue)
+		})
+	}
+}
+
+func TestWriteResponsePrimitive(t *testing.T) {
+	for _, contentType := range []string{"application/json;charset=utf-8", "application/json;charset=iso-8859-1", "application/json"} {
+		t.Run(contentType, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := httpjson.WriteResponse(rr, http.StatusOK, contentType, "ok £☺")
+			qt.Assert(t, err, qt.IsNil)
+			var v string
+			err = httpjson.UnmarshalResponse(rr.Result(), &v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v, qt.Equals, "ok £☺")
+		})
+	}
+}
+
+type testValue struct {
+	S string `json:"s"`
+}
+
+type versionedValue struct {
+	S string `json:"s"`
+}
+
+func (versionedValue) ContentType() string {
+	return "application/vnd.test.v2+json;charset=us-ascii"
+}
+
+func BenchmarkUnmarshalResponse(b *testing.B) {
+	// A UTF-8 document of about 5MB.
+	items := make([]testValue, 50000)
+	for i := range items {
+		items[i].S = fmt.Sprintf("item %d ☺ %s", i, strings.Repeat("x", 80))
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmark := func(b *testing.B, contentLength int64) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			resp := &http.Response{
+				Header:        http.Header{"Content-Type": {"application/json;charset=utf-8"}},
+				Body:          io.NopCloser(bytes.NewReader(body)),
+				ContentLength: contentLength,
+			}
+			var v []testValue
+			if err := httpjson.UnmarshalResponse(resp, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("content_length", func(b *testing.B) {
+		benchmark(b, int64(len(body)))
+	})
+	b.Run("unknown_length", func(b *testing.B) {
+		benchmark(b, -1)
+	})
+}
+
+func BenchmarkMarshalCharset(b *testing.B) {
+	v := testValue{S: "£☺"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := httpjson.Marshal("application/json;charset=iso-8859-1", v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalCharset(b *testing.B) {
+	buf := []byte("{\"s\":\"\xa3\\u263a\"}")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v testValue
+		if err := httpjson.Unmarshal(buf, "application/json;charset=iso-8859-1", &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalResponseContextCanceled(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"s":`))
+		w.(http.Flusher).Flush()
+		<-stop
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	qt.Assert(t, err, qt.IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var v testValue
+	err = httpjson.UnmarshalResponse(resp, &v)
+	qt.Check(t, err, qt.ErrorIs, context.Canceled)
+}
+
+func TestResponseCharset(t *testing.T) {
+	for contentType, expect := range map[string]string{
+		"application/json;charset=iso-8859-1":    "iso-8859-1",
+		"application/json; charset=\"UTF-16LE\"": "UTF-16LE",
+		"application/json":                       "utf-8",
+		"":                                       "utf-8",
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {contentType}}}
+		qt.Check(t, httpjson.ResponseCharset(resp), qt.Equals, expect, qt.Commentf("%q", contentType))
+	}
+}
+
+func TestJSONTransformerShortDst(t *testing.T) {
+	c := qt.New(t)
+	src := []byte(`{"s":"£☺😂 ☺☺☺ abc £"}`)
+	want, _, err := transform.Bytes(httpjson.NewJSONTransformer(charmap.ISO8859_1), src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(want), qt.Equals, "{\"s\":\"\xa3\\u263a\\ud83d\\ude02 \\u263a\\u263a\\u263a abc \xa3\"}")
+	for size := 1; size <= 30; size++ {
+		c.Run(fmt.Sprintf("dst%d", size), func(c *qt.C) {
+			tr := httpjson.NewJSONTransformer(charmap.ISO8859_1)
+			dst := make([]byte, size)
+			var got []byte
+			in := src
+			for i := 0; i < 1000; i++ {
+				nDst, nSrc, err := tr.Transform(dst, in, true)
+				got = append(got, dst[:nDst]...)
+				in = in[nSrc:]
+				if err == nil {
+					break
+				}
+				c.Assert(err, qt.Equals, transform.ErrShortDst)
+			}
+			c.Check(string(got), qt.Equals, string(want))
+		})
+	}
+}
+
+func TestWriteResponseAfterWrite(t *testing.T) {
+	errc := make(chan [2]error, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var err1 error
+		switch req.URL.Path {
+		case "/write":
+			w.Write([]byte("x"))
+		case "/twice":
+			err1 = httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: "a"})
+		}
+		err2 := httpjson.WriteResponse(w, http.StatusCreated, "application/vnd.test+json", testValue{S: "b"})
+		errc <- [2]error{err1, err2}
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.Start()
+	defer srv.Close()
+
+	// A second call is dropped as it exceeds the Content-Length
+	// declared by the first.
+	resp, err := http.Get(srv.URL + "/twice")
+	qt.Assert(t, err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	qt.Assert(t, err, qt.IsNil)
+	errs := <-errc
+	qt.Check(t, errs[0], qt.IsNil)
+	qt.Check(t, errs[1], qt.ErrorIs, http.ErrContentLength)
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+	qt.Check(t, string(body), qt.Equals, `{"s":"a"}`)
+
+	// After w.Write the body is appended, but the status and headers
+	// are lost.
+	resp, err = http.Get(srv.URL + "/write")
+	qt.Assert(t, err, qt.IsNil)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	qt.Assert(t, err, qt.IsNil)
+	errs = <-errc
+	qt.Check(t, errs[1], qt.IsNil)
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "text/plain; charset=utf-8")
+	qt.Check(t, string(body), qt.Equals, `x{"s":"b"}`)
+}
+
+func TestWriteResponseNothing(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set("X-Test", "test")
+	err := httpjson.WriteResponse(rr, 0, "", nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.Flushed, qt.IsFalse)
+	qt.Check(t, rr.Body.Len(), qt.Equals, 0)
+	// The handler can still write its own response.
+	rr.WriteHeader(http.StatusTeapot)
+	qt.Check(t, rr.Result().StatusCode, qt.Equals, http.StatusTeapot)
+}
+
+func TestWriteResponseOmitContentLength(t *testing.T) {
+	rr := httptest.NewRecorder()
+	err := httpjson.MarshalOptions{OmitContentLength: true}.WriteResponse(rr, http.StatusOK, "", testValue{S: "a"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.Header().Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+	qt.Check(t, rr.Header().Values("Content-Length"), qt.HasLen, 0)
+	qt.Check(t, rr.Body.String(), qt.Equals, `{"s":"a"}`)
+}
+
+// A gzipResponseWriter is an http.ResponseWriter that compresses the
+// body written to it, in the manner of compression middleware.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+func TestWriteResponseOmitContentLengthMiddleware(t *testing.T) {
+	v := testValue{S: strings.Repeat("a", 4096)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		httpjson.MarshalOptions{OmitContentLength: true}.WriteResponse(&gzipResponseWriter{w, zw}, http.StatusOK, "", v)
+	}))
+	defer srv.Close()
+
+	var got testValue
+	err := httpjson.Get(context.Background(), srv.URL, &got)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, got, qt.DeepEquals, v)
+}
+
+var sniffCharsetTests = []struct {
+	name        string
+	contentType string
+	body        string
+	expectValue testValue
+}{{
+	name:        "utf-8",
+	contentType: "application/json",
+	body:        `{"s":"☺"}`,
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "latin-1",
+	contentType: "application/json",
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "£"},
+}, {
+	name:        "windows-1252",
+	contentType: "application/json",
+	body:        "{\"s\":\"\x80\"}",
+	expectValue: testValue{S: "€"},
+}, {
+	name:        "utf-16_bom",
+	contentType: "application/json",
+	body:        "\xff\xfe{\x00\"\x00s\x00\"\x00:\x00\"\x00\x3a\x26\"\x00}\x00",
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16be",
+	contentType: "application/json",
+	body:        "\x00{\x00\"\x00s\x00\"\x00:\x00\"\x26\x3a\x00\"\x00}",
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "utf-16le",
+	contentType: "application/json",
+	body:        "{\x00\"\x00s\x00\"\x00:\x00\"\x00\x3a\x26\"\x00}\x00",
+	expectValue: testValue{S: "☺"},
+}, {
+	name:        "declared_charset",
+	contentType: "application/json;charset=utf-8",
+	body:        "{\"s\":\"\xa3\"}",
+	expectValue: testValue{S: "\ufffd"},
+}}
+
+func TestUnmarshalOptionsSniffCharset(t *testing.T) {
+	for _, test := range sniffCharsetTests {
+		t.Run(test.name, func(t *testing.T) {
+			var v testValue
+			err := httpjson.UnmarshalOptions{SniffCharset: true}.Unmarshal([]byte(test.body), test.contentType, &v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v, qt.Equals, test.expectValue)
+		})
+	}
+}
+
+func TestMarshalOptionsMarshalFunc(t *testing.T) {
+	var calls []interface{}
+	opts := httpjson.MarshalOptions{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			calls = append(calls, v)
+			return []byte(`{"stub":"£"}`), nil
+		},
+	}
+	buf, err := opts.Marshal("application/json;charset=us-ascii", testValue{S: "x"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"stub":"\u00a3"}`)
+	qt.Check(t, calls, qt.DeepEquals, []interface{}{testValue{S: "x"}})
+
+	opts.Indent = "\t"
+	buf, err = opts.Marshal("application/json;charset=iso-8859-1", testValue{S: "y"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, "{\n\t\"stub\": \"\xa3\"\n}")
+
+	opts.MarshalFunc = func(v interface{}) ([]byte, error) {
+		return nil, errors.New("stub error")
+	}
+	_, err = opts.Marshal("", testValue{S: "z"})
+	qt.Check(t, err, qt.ErrorMatches, `stub error`)
+}
+
+func TestUnmarshalOptionsUnmarshalFunc(t *testing.T) {
+	var data []string
+	opts := httpjson.UnmarshalOptions{
+		UnmarshalFunc: func(buf []byte, v interface{}) error {
+			data = append(data, string(buf))
+			v.(*testValue).S = "stub"
+			return nil
+		},
+	}
+	var v testValue
+	err := opts.Unmarshal([]byte("{\"s\":\"\xa3\"}"), "application/json;charset=iso-8859-1", &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v.S, qt.Equals, "stub")
+	qt.Check(t, data, qt.DeepEquals, []string{`{"s":"£"}`})
+}
+
+func TestMarshalManyEscapes(t *testing.T) {
+	// Every character expands when escaped, so the encoded value is
+	// much larger than the UTF-8 it was encoded from.
+	buf, err := httpjson.Marshal("application/json;charset=us-ascii", testValue{S: strings.Repeat("☺😂", 1000)})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"s":"`+strings.Repeat(`\u263a\ud83d\ude02`, 1000)+`"}`)
 }
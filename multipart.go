@@ -0,0 +1,160 @@
+package httpjson
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// A MultipartFile is a file to be sent as a part of a multipart request
+// body.
+type MultipartFile struct {
+	// FieldName is the name of the form field containing the file.
+	FieldName string
+
+	// FileName is the name of the file.
+	FileName string
+
+	// ContentType is the Content-Type of the file. If this is empty
+	// "application/octet-stream" is used.
+	ContentType string
+
+	// Body is read to produce the content of the file. If Body is
+	// also an io.Closer it will be closed once it has been read.
+	Body io.Reader
+}
+
+// MarshalMultipartRequest creates a new http.Request with the given method
+// and URL and a multipart/form-data body. The first part of the body is
+// a form field with the given name containing the JSON encoding of v,
+// this is encoded in the character set specified by contentType in the
+// same way as MarshalRequest. If contentType specifies more than one
+// charset an error matching ErrDuplicateCharset is returned. The JSON
+// part is followed by a part for each of the given files.
+//
+// The body is produced as the request is sent, so only the JSON part is
+// held in memory regardless of the size of the files. This means the
+// request has an unknown ContentLength and will be sent with chunked
+// transfer encoding. The request does not have a GetBody method, so it
+// cannot be replayed if the server responds with a redirect that
+// requires the body to be resent. The body is only written once it is
+// first read, if the request is never sent the caller must close the
+// request body to release the files.
+func MarshalMultipartRequest(method, url, name, contentType string, v interface{}, files ...MultipartFile) (*http.Request, error) {
+	contentType = MarshalOptions{}.valueContentType(contentType, v)
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	body, err := MarshalOptions{}.marshal(charset, v)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	mb := &multipartBody{
+		mw:          multipart.NewWriter(pw),
+		name:        name,
+		contentType: contentType,
+		body:        body,
+		files:       files,
+		pr:          pr,
+		pw:          pw,
+	}
+	req, err := http.NewRequest(method, url, mb)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", mb.mw.FormDataContentType())
+	return req, nil
+}
+
+// A multipartBody is a request body that writes the multipart body as
+// it is read. As with streamBody, writing is only started by the first
+// call to Read, so that a request that is never sent does not leave a
+// goroutine blocked writing to the pipe.
+type multipartBody struct {
+	mw          *multipart.Writer
+	name        string
+	contentType string
+	body        []byte
+	files       []MultipartFile
+
+	once sync.Once
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+}
+
+// Read implements io.Reader.
+func (b *multipartBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { go b.write() })
+	return b.pr.Read(p)
+}
+
+// Close implements io.Closer. Closing the body stops any write in
+// progress, if the body has not been read the files are closed
+// immediately.
+func (b *multipartBody) Close() error {
+	b.once.Do(func() { closeFiles(b.files) })
+	return b.pr.Close()
+}
+
+// write writes the multipart body to the pipe, closing it with any error
+// encountered so that the error is returned from Read.
+func (b *multipartBody) write() {
+	b.pw.CloseWithError(writeMultipart(b.mw, b.name, b.contentType, b.body, b.files))
+}
+
+// writeMultipart writes the multipart body containing the JSON part and
+// the files to mw. Every file is closed, whether or not it is written.
+func writeMultipart(mw *multipart.Writer, name, contentType string, body []byte, files []MultipartFile) error {
+	defer closeFiles(files)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(name)))
+	h.Set("Content-Type", contentType)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	for _, f := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(f.FieldName), escapeQuotes(f.FileName)))
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h.Set("Content-Type", ct)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f.Body); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func closeFiles(files []MultipartFile) {
+	for _, f := range files {
+		if c, ok := f.Body.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,113 @@
+package httpjson_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestMarshalMultipartRequest(t *testing.T) {
+	file := strings.Repeat("0123456789", 100000)
+	type part struct {
+		name, fileName, contentType, body string
+	}
+	var parts []part
+	var transferEncoding []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		transferEncoding = req.TransferEncoding
+		mr, err := req.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			buf, err := io.ReadAll(p)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			parts = append(parts, part{p.FormName(), p.FileName(), p.Header.Get("Content-Type"), string(buf)})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	req, err := httpjson.MarshalMultipartRequest("POST", srv.URL, "metadata", "application/json;charset=us-ascii", testValue{S: "☺"}, httpjson.MultipartFile{
+		FieldName: "file",
+		FileName:  "digits.txt",
+		Body:      strings.NewReader(file),
+	}, httpjson.MultipartFile{
+		FieldName:   "note",
+		FileName:    `a "quoted" name`,
+		ContentType: "text/plain",
+		Body:        io.NopCloser(strings.NewReader("hello")),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.ContentLength, qt.Equals, int64(-1))
+	qt.Check(t, req.GetBody, qt.IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	resp.Body.Close()
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusNoContent)
+	qt.Check(t, transferEncoding, qt.DeepEquals, []string{"chunked"})
+	qt.Assert(t, parts, qt.HasLen, 3)
+	qt.Check(t, parts[0].name, qt.Equals, "metadata")
+	qt.Check(t, parts[0].contentType, qt.Equals, "application/json;charset=us-ascii")
+	qt.Check(t, parts[0].body, qt.Equals, `{"s":"\u263a"}`)
+	var v testValue
+	err = json.Unmarshal([]byte(parts[0].body), &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v.S, qt.Equals, "☺")
+	qt.Check(t, parts[1], qt.Equals, part{"file", "digits.txt", "application/octet-stream", file})
+	qt.Check(t, parts[2], qt.Equals, part{"note", `a "quoted" name`, "text/plain", "hello"})
+}
+
+func TestMarshalMultipartRequestMarshalError(t *testing.T) {
+	_, err := httpjson.MarshalMultipartRequest("POST", "https://test.example.com", "metadata", "application/json;charset=no-such", testValue{S: "☺"})
+	qt.Check(t, err, qt.ErrorMatches, `ianaindex: invalid encoding name`)
+}
+
+func TestMarshalMultipartRequestDuplicateCharset(t *testing.T) {
+	_, err := httpjson.MarshalMultipartRequest("POST", "https://test.example.com", "metadata", "application/json;charset=iso-8859-1;charset=utf-8", testValue{S: "☺"})
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+}
+
+func TestMarshalMultipartRequestUnread(t *testing.T) {
+	f := &closeRecorder{Reader: strings.NewReader("hello")}
+	req, err := httpjson.MarshalMultipartRequest("POST", "https://test.example.com", "metadata", "", testValue{S: "a"}, httpjson.MultipartFile{
+		FieldName: "file",
+		FileName:  "hello.txt",
+		Body:      f,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, f.closed, qt.IsFalse)
+	qt.Check(t, req.Body.Close(), qt.IsNil)
+	qt.Check(t, f.closed, qt.IsTrue)
+	_, err = req.Body.Read(make([]byte, 10))
+	qt.Check(t, err, qt.Equals, io.ErrClosedPipe)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
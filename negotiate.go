@@ -0,0 +1,202 @@
+package httpjson
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// An acceptItem is a single element of an Accept-style header.
+type acceptItem struct {
+	// value is the media range, coding or charset being accepted. It
+	// is converted to lower case.
+	value string
+
+	// params contains any parameters, other than q, of the item.
+	params map[string]string
+
+	// q is the quality value of the item.
+	q float64
+}
+
+// parseAccept parses the value of an Accept, Accept-Charset or
+// Accept-Encoding header. Items with a missing or invalid quality value
+// are given a quality of 1.
+func parseAccept(header string) []acceptItem {
+	var items []acceptItem
+	for _, s := range strings.Split(header, ",") {
+		parts := strings.Split(s, ";")
+		item := acceptItem{
+			value: strings.ToLower(strings.TrimSpace(parts[0])),
+			q:     1,
+		}
+		if item.value == "" {
+			continue
+		}
+		for _, p := range parts[1:] {
+			k, v := p, ""
+			if i := strings.Index(p, "="); i >= 0 {
+				k, v = p[:i], p[i+1:]
+			}
+			k = strings.ToLower(strings.TrimSpace(k))
+			v = strings.Trim(strings.TrimSpace(v), `"`)
+			if k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					item.q = q
+				}
+				continue
+			}
+			if item.params == nil {
+				item.params = make(map[string]string)
+			}
+			item.params[k] = v
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// acceptsEncoding determines whether the given Accept-Encoding header
+// allows the given content coding. An explicit entry for the coding
+// takes precedence over a "*" entry.
+func acceptsEncoding(header, coding string) bool {
+	coding = strings.ToLower(coding)
+	wildcard := false
+	for _, item := range parseAccept(header) {
+		switch item.value {
+		case coding:
+			return item.q > 0
+		case "*":
+			wildcard = item.q > 0
+		}
+	}
+	return wildcard
+}
+
+// WriteNegotiated writes the JSON encoding of v as the body of an HTTP
+// response in the same way as WriteResponse, choosing the content type
+// of the response from the Accept and Accept-Charset headers of req.
+//
+// The media type is the media type of a v that implements ContentTyper
+// or "application/json", whichever is most preferred by the Accept
+// header, and the charset is "utf-8" or "us-ascii", along with the
+// charset of a v that implements ContentTyper, whichever is most
+// preferred by the Accept-Charset header. If a header is missing its
+// first choice is used. If the Accept header includes a "pretty"
+// parameter for the chosen media type, for example
+// "application/json;pretty", the document is indented. If nothing in a
+// header matches, the response falls back to
+// "application/json;charset=utf-8" rather than failing, as clients
+// often send headers that do not reflect what they can process.
+//
+// A "Vary: Accept, Accept-Charset" header is added to the response.
+func WriteNegotiated(w http.ResponseWriter, req *http.Request, statusCode int, v interface{}) error {
+	return MarshalOptions{}.WriteNegotiated(w, req, statusCode, v)
+}
+
+// WriteNegotiated writes the JSON encoding of v as the body of an HTTP
+// response in the same way as the WriteNegotiated function, using the
+// options in o. A pretty response uses o.Indent and o.IndentPrefix if
+// Indent is set, otherwise it is indented with two spaces.
+func (o MarshalOptions) WriteNegotiated(w http.ResponseWriter, req *http.Request, statusCode int, v interface{}) error {
+	mt, charset := "application/json", ""
+	if ct, ok := v.(ContentTyper); ok {
+		mt1, params, err := mime.ParseMediaType(ct.ContentType())
+		if err == nil {
+			mt, charset = mt1, strings.ToLower(params["charset"])
+		}
+	}
+	mediaTypes := []string{mt}
+	if mt != "application/json" {
+		mediaTypes = append(mediaTypes, "application/json")
+	}
+	charsets := []string{"utf-8", "us-ascii"}
+	if charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		charsets = append([]string{charset}, charsets...)
+	}
+
+	mt, params := negotiateMediaType(req.Header.Get("Accept"), mediaTypes)
+	if mt == "" {
+		mt, params = "application/json", nil
+	}
+	charset = negotiateCharset(req.Header.Get("Accept-Charset"), charsets)
+	if charset == "" {
+		charset = "utf-8"
+	}
+	if _, ok := params["pretty"]; ok && o.Indent == "" {
+		o.Indent = "  "
+	}
+	w.Header().Add("Vary", "Accept, Accept-Charset")
+	return o.WriteResponse(w, statusCode, mt+";charset="+charset, v)
+}
+
+// negotiateMediaType chooses the media type in offers that is most
+// preferred by the given Accept header, returning it along with the
+// parameters of the header item that matched it. Offers that are equally
+// preferred are chosen in the order given. If the header is empty the
+// first offer is chosen, if no offer is acceptable the result is empty.
+func negotiateMediaType(header string, offers []string) (string, map[string]string) {
+	if strings.TrimSpace(header) == "" {
+		return offers[0], nil
+	}
+	items := parseAccept(header)
+	best, bestQ := -1, 0.0
+	var bestParams map[string]string
+	for i, offer := range offers {
+		typ := offer[:strings.Index(offer, "/")+1]
+		// Use the most specific matching media range.
+		specificity, q := 0, 0.0
+		var params map[string]string
+		for _, item := range items {
+			s := 0
+			switch item.value {
+			case offer:
+				s = 3
+			case typ + "*":
+				s = 2
+			case "*/*":
+				s = 1
+			}
+			if s > specificity {
+				specificity, q, params = s, item.q, item.params
+			}
+		}
+		if q > bestQ {
+			best, bestQ, bestParams = i, q, params
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+	return offers[best], bestParams
+}
+
+// negotiateCharset chooses the charset in offers that is most preferred
+// by the given Accept-Charset header. Offers that are equally preferred
+// are chosen in the order given. If the header is empty the first offer
+// is chosen, if no offer is acceptable the result is empty.
+func negotiateCharset(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	items := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, matched := 0.0, false
+		for _, item := range items {
+			switch item.value {
+			case offer:
+				q, matched = item.q, true
+			case "*":
+				if !matched {
+					q = item.q
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
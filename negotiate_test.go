@@ -0,0 +1,131 @@
+package httpjson_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+var writeNegotiatedTests = []struct {
+	name              string
+	accept            string
+	acceptCharset     string
+	v                 interface{}
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "no_headers",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "wildcards",
+	accept:            "text/html, */*;q=0.1",
+	acceptCharset:     "*",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "us-ascii",
+	accept:            "application/json",
+	acceptCharset:     "us-ascii, utf-8;q=0.5",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=us-ascii",
+	expectBody:        `{"s":"\u263a"}`,
+}, {
+	name:              "utf-8_refused",
+	acceptCharset:     "utf-8;q=0, *",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=us-ascii",
+	expectBody:        `{"s":"\u263a"}`,
+}, {
+	name:              "pretty",
+	accept:            "application/json;pretty",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        "{\n  \"s\": \"☺\"\n}",
+}, {
+	name:              "pretty_other_type",
+	accept:            "application/json, text/html;pretty",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "nothing_matches",
+	accept:            "text/html",
+	acceptCharset:     "iso-8859-1",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "content_typer",
+	v:                 versionedValue{S: "☺"},
+	expectContentType: "application/vnd.test.v2+json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "content_typer_plain_json",
+	accept:            "application/vnd.test.v2+json;q=0.5, application/json",
+	v:                 versionedValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "content_typer_specific",
+	accept:            "application/*;q=0.5, application/vnd.test.v2+json;q=0.8",
+	v:                 versionedValue{S: "☺"},
+	expectContentType: "application/vnd.test.v2+json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "content_typer_charset",
+	acceptCharset:     "iso-8859-1, us-ascii;q=0.5",
+	v:                 latin1Value{S: "£"},
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}",
+}}
+
+// A latin1Value is a value that is sent as ISO 8859-1 by default.
+type latin1Value struct {
+	S string `json:"s"`
+}
+
+func (latin1Value) ContentType() string {
+	return "application/json;charset=iso-8859-1"
+}
+
+func TestWriteNegotiated(t *testing.T) {
+	for _, test := range writeNegotiatedTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			if test.acceptCharset != "" {
+				req.Header.Set("Accept-Charset", test.acceptCharset)
+			}
+			rr := httptest.NewRecorder()
+			err := httpjson.WriteNegotiated(rr, req, http.StatusOK, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, resp.Header.Get("Vary"), qt.Equals, "Accept, Accept-Charset")
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestMarshalOptionsWriteNegotiated(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json;pretty")
+	rr := httptest.NewRecorder()
+	err := httpjson.MarshalOptions{Indent: "\t"}.WriteNegotiated(rr, req, http.StatusCreated, testValue{S: "☺"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.Code, qt.Equals, http.StatusCreated)
+	qt.Check(t, rr.Body.String(), qt.Equals, "{\n\t\"s\": \"☺\"\n}")
+}
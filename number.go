@@ -0,0 +1,106 @@
+package httpjson
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// plainIntegers returns the JSON document src with every number that has
+// an integer value written without an exponent. If no number needs to be
+// rewritten src is returned.
+func plainIntegers(src []byte) []byte {
+	if bytes.IndexAny(src, "eE") < 0 {
+		return src
+	}
+	dst := make([]byte, 0, len(src))
+	inString, escaped := false, false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '-' || '0' <= c && c <= '9':
+			j := i + 1
+			for j < len(src) && isNumberByte(src[j]) {
+				j++
+			}
+			dst = append(dst, plainInteger(src[i:j])...)
+			i = j
+			continue
+		}
+		dst = append(dst, c)
+		i++
+	}
+	return dst
+}
+
+func isNumberByte(c byte) bool {
+	return '0' <= c && c <= '9' || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+}
+
+// maxPlainExponent is the largest exponent that plainInteger will expand,
+// which is enough for any float64.
+const maxPlainExponent = 400
+
+// plainInteger returns the JSON number n written without an exponent if
+// n has an integer value, otherwise n is returned unchanged.
+func plainInteger(n []byte) []byte {
+	i := bytes.IndexAny(n, "eE")
+	if i < 0 {
+		return n
+	}
+	exp, err := strconv.Atoi(string(n[i+1:]))
+	if err != nil || exp > maxPlainExponent || exp < -maxPlainExponent {
+		return n
+	}
+	mant := n[:i]
+	neg := len(mant) > 0 && mant[0] == '-'
+	if neg {
+		mant = mant[1:]
+	}
+	digits := mant
+	point := len(mant)
+	if dot := bytes.IndexByte(mant, '.'); dot >= 0 {
+		digits = append(append([]byte(nil), mant[:dot]...), mant[dot+1:]...)
+		point = dot
+	}
+	point += exp
+	for k := point; k < len(digits); k++ {
+		if k >= 0 && digits[k] != '0' {
+			// There is a fractional part.
+			return n
+		}
+	}
+	if point < 0 {
+		point = 0
+	}
+	var out []byte
+	if neg {
+		out = append(out, '-')
+	}
+	start := len(out)
+	for k := 0; k < point; k++ {
+		d := byte('0')
+		if k < len(digits) {
+			d = digits[k]
+		}
+		if d == '0' && len(out) == start {
+			// Skip leading zeros.
+			continue
+		}
+		out = append(out, d)
+	}
+	if len(out) == start {
+		out = append(out, '0')
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package httpjson
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+)
+
+// An Option configures a Client created by NewClient.
+type Option func(*Client)
+
+// NewClient creates a new Client configured with the given options. The
+// options are applied in order, so a later option overrides the effect
+// of an earlier one. A Client may also be configured by setting its
+// fields directly, NewClient() returns a client equivalent to the zero
+// value.
+func NewClient(opts ...Option) *Client {
+	c := new(Client)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithHTTPClient returns an Option that sets the http.Client used to
+// make requests, see Client.HTTPClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = client
+	}
+}
+
+// WithMaxResponseBytes returns an Option that sets the maximum size of
+// response bodies, see Client.MaxResponseBytes.
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) {
+		c.MaxResponseBytes = n
+	}
+}
+
+// WithIsJSONContentType returns an Option that sets the function used to
+// determine if a response has a JSON content type, see
+// Client.IsJSONContentType.
+func WithIsJSONContentType(f func(contentType string) bool) Option {
+	return func(c *Client) {
+		c.IsJSONContentType = f
+	}
+}
+
+// WithMaxConcurrent returns an Option that limits the number of calls
+// in progress at once to n, using a new Limiter, see Client.Limiter.
+func WithMaxConcurrent(n int) Option {
+	return func(c *Client) {
+		c.Limiter = NewLimiter(n)
+	}
+}
+
+// WithHeader returns an Option that sets a header that is sent with
+// every request, replacing any values previously set for the same
+// header, see Client.Header.
+func WithHeader(key string, values ...string) Option {
+	return func(c *Client) {
+		if c.Header == nil {
+			c.Header = make(http.Header)
+		}
+		c.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+}
+
+// WithBasicAuth returns an Option that sends the given username and
+// password with every request using HTTP Basic Authentication, as an
+// Authorization header in Client.Header. It replaces any bearer token
+// set by an earlier option.
+func WithBasicAuth(username, password string) Option {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	setHeader := WithHeader("Authorization", "Basic "+auth)
+	return func(c *Client) {
+		setHeader(c)
+		c.BearerToken = nil
+	}
+}
+
+// WithBearerToken returns an Option that sends the given token with
+// every request in an "Authorization: Bearer" header.
+func WithBearerToken(token string) Option {
+	return WithBearerTokenFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithBearerTokenFunc returns an Option that sets the function used to
+// obtain a bearer token for each request, see Client.BearerToken.
+func WithBearerTokenFunc(f func(ctx context.Context) (string, error)) Option {
+	return func(c *Client) {
+		c.BearerToken = f
+	}
+}
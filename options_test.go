@@ -0,0 +1,152 @@
+package httpjson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestNewClient(t *testing.T) {
+	qt.Check(t, httpjson.NewClient(), qt.DeepEquals, &httpjson.Client{})
+
+	hc1 := &http.Client{}
+	hc2 := &http.Client{}
+	cl := httpjson.NewClient(
+		httpjson.WithHTTPClient(hc1),
+		httpjson.WithMaxResponseBytes(1024),
+		httpjson.WithIsJSONContentType(httpjson.StrictIsJSONContentType),
+		httpjson.WithHeader("x-test", "a"),
+		httpjson.WithHeader("Authorization", "Bearer token"),
+		httpjson.WithHTTPClient(hc2),
+		httpjson.WithMaxResponseBytes(2048),
+		httpjson.WithHeader("X-Test", "b", "c"),
+	)
+	qt.Check(t, cl.HTTPClient, qt.Equals, hc2)
+	qt.Check(t, cl.MaxResponseBytes, qt.Equals, int64(2048))
+	qt.Check(t, cl.IsJSONContentType("text/json"), qt.IsFalse)
+	qt.Check(t, cl.Header, qt.DeepEquals, http.Header{
+		"X-Test":        {"b", "c"},
+		"Authorization": {"Bearer token"},
+	})
+}
+
+func TestNewClientHeader(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: "ok"})
+	}))
+	defer srv.Close()
+
+	cl := httpjson.NewClient(httpjson.WithHeader("X-Test", "a"))
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "ok")
+	qt.Check(t, header.Get("X-Test"), qt.Equals, "a")
+}
+
+var authOptionTests = []struct {
+	name                string
+	opts                []httpjson.Option
+	ctxHeader           http.Header
+	expectAuthorization string
+}{{
+	name:                "basic",
+	opts:                []httpjson.Option{httpjson.WithBasicAuth("user", "pass")},
+	expectAuthorization: "Basic dXNlcjpwYXNz",
+}, {
+	name:                "bearer",
+	opts:                []httpjson.Option{httpjson.WithBearerToken("token1")},
+	expectAuthorization: "Bearer token1",
+}, {
+	name: "bearer_replaces_basic",
+	opts: []httpjson.Option{
+		httpjson.WithBasicAuth("user", "pass"),
+		httpjson.WithBearerToken("token1"),
+	},
+	expectAuthorization: "Bearer token1",
+}, {
+	name: "basic_replaces_bearer",
+	opts: []httpjson.Option{
+		httpjson.WithBearerToken("token1"),
+		httpjson.WithBasicAuth("user", "pass"),
+	},
+	expectAuthorization: "Basic dXNlcjpwYXNz",
+}, {
+	name:                "context_header",
+	opts:                []httpjson.Option{httpjson.WithBearerToken("token1")},
+	ctxHeader:           http.Header{"Authorization": {"Bearer token2"}},
+	expectAuthorization: "Bearer token2",
+}}
+
+func TestAuthOptions(t *testing.T) {
+	for _, test := range authOptionTests {
+		t.Run(test.name, func(t *testing.T) {
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				header = req.Header
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			cl := httpjson.NewClient(test.opts...)
+			ctx := httpjson.ContextWithHeader(context.Background(), test.ctxHeader)
+			err := cl.Get(ctx, srv.URL, nil)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, header["Authorization"], qt.DeepEquals, []string{test.expectAuthorization})
+		})
+	}
+}
+
+func TestWithBearerTokenFunc(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = append(auth, req.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	n := 0
+	cl := httpjson.NewClient(httpjson.WithBearerTokenFunc(func(ctx context.Context) (string, error) {
+		n++
+		if n == 3 {
+			return "", errors.New("token expired")
+		}
+		return fmt.Sprintf("token%d", n), nil
+	}))
+	for i := 0; i < 2; i++ {
+		err := cl.Post(context.Background(), srv.URL, testValue{S: "☺"}, nil)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	qt.Check(t, auth, qt.DeepEquals, []string{"Bearer token1", "Bearer token2"})
+
+	err := cl.Post(context.Background(), srv.URL, testValue{S: "☺"}, nil)
+	qt.Check(t, err, qt.ErrorMatches, `POST http://.*: token expired`)
+	qt.Check(t, auth, qt.HasLen, 2)
+}
+
+func TestBearerTokenRedacted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	cl := httpjson.NewClient(httpjson.WithBearerToken("secret-token"))
+	cl.Debug = true
+	cl.DebugWriter = &buf
+	err := cl.Get(context.Background(), srv.URL, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, strings.Contains(buf.String(), "Authorization:"), qt.IsTrue)
+	qt.Check(t, strings.Contains(buf.String(), "secret-token"), qt.IsFalse)
+}
@@ -0,0 +1,292 @@
+package httpjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrTooManyPages is the error returned by Client.GetAllOffset when more
+// than the maximum number of pages would be fetched.
+var ErrTooManyPages = errors.New("too many pages")
+
+// ErrCrossOriginLink is the error returned by Client.GetAll and
+// Client.GetAllOffset when a page would be fetched from a different
+// origin, that is a different scheme, host or port, from the first page,
+// which would send the Client's credentials to another server.
+var ErrCrossOriginLink = errors.New("link to a different origin")
+
+// OffsetPagination describes a list that is split into pages selected
+// using offset and limit query parameters.
+type OffsetPagination struct {
+	// OffsetParam is the name of the query parameter containing the
+	// offset of the first element of the page. If this is empty
+	// "offset" is used.
+	OffsetParam string
+
+	// LimitParam is the name of the query parameter containing the
+	// maximum number of elements in the page. If this is empty "limit"
+	// is used.
+	LimitParam string
+
+	// PageSize is the number of elements requested in each page, it
+	// must be greater than zero.
+	PageSize int
+
+	// PageNumbers causes OffsetParam to contain the number of the page,
+	// starting from 1, rather than the offset of the first element, as
+	// used by page/per_page style APIs.
+	PageNumbers bool
+
+	// MaxPages is the maximum number of pages that will be fetched. If
+	// this is zero there is no limit.
+	MaxPages int
+
+	// OnPage, if not nil, is called with each page after it has been
+	// decoded. The page has the same slice type as the value passed to
+	// GetAllOffset. If OnPage returns an error no more pages are fetched
+	// and the error is returned.
+	OnPage func(page interface{}) error
+}
+
+// GetAllOffset retrieves every page of a list described by p, starting at
+// the given URL, and appends the elements of each page to the slice
+// pointed to by v. Each page is retrieved in the same way as Get, with
+// the query parameters from p added to url, and must be a JSON array.
+// Pages are fetched until a page contains fewer than p.PageSize
+// elements. A page URL on a different origin from url results in
+// ErrCrossOriginLink.
+//
+// If p.MaxPages pages have been fetched and the last page was full then
+// GetAllOffset returns ErrTooManyPages. If an error is returned v
+// contains the elements from every page that was successfully decoded.
+func (c *Client) GetAllOffset(ctx context.Context, url string, p OffsetPagination, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("GetAllOffset: v must be a non-nil pointer to a slice")
+	}
+	if p.PageSize <= 0 {
+		return errors.New("GetAllOffset: PageSize must be greater than zero")
+	}
+	for n := 0; p.MaxPages <= 0 || n < p.MaxPages; n++ {
+		offset := n * p.PageSize
+		if p.PageNumbers {
+			offset = n + 1
+		}
+		pageURL, err := p.pageURL(url, offset)
+		if err != nil {
+			return err
+		}
+		if err := checkOrigin(url, pageURL); err != nil {
+			return fmt.Errorf("GetAllOffset: %w", err)
+		}
+		page := reflect.New(rv.Elem().Type())
+		if err := c.Get(ctx, pageURL, page.Interface()); err != nil {
+			return err
+		}
+		rv.Elem().Set(reflect.AppendSlice(rv.Elem(), page.Elem()))
+		if p.OnPage != nil {
+			if err := p.OnPage(page.Elem().Interface()); err != nil {
+				return err
+			}
+		}
+		if page.Elem().Len() < p.PageSize {
+			return nil
+		}
+	}
+	return ErrTooManyPages
+}
+
+// pageURL returns rawurl with the query parameters set to select the
+// page with the given offset.
+func (p OffsetPagination) pageURL(rawurl string, offset int) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	offsetParam := p.OffsetParam
+	if offsetParam == "" {
+		offsetParam = "offset"
+	}
+	limitParam := p.LimitParam
+	if limitParam == "" {
+		limitParam = "limit"
+	}
+	q := u.Query()
+	q.Set(offsetParam, strconv.Itoa(offset))
+	q.Set(limitParam, strconv.Itoa(p.PageSize))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// GetAll retrieves every page of a list that is paginated using Link
+// headers, as described by RFC 8288, starting at the given URL, and
+// appends the elements of each page to the slice pointed to by v. Each
+// page is retrieved in the same way as Get and must be a JSON array, the
+// pages are decoded independently so each may use a different character
+// set. After each page the link with the relation type "next" is
+// followed, resolved relative to the URL of the page, until a page
+// without one is received or ctx is done.
+//
+// If a page cannot be decoded the returned error includes the URL of the
+// page. If a next link refers to a page that has already been fetched an
+// error is returned rather than looping forever. A next link to a
+// different origin from the given URL is not followed, as the request
+// would carry the Client's credentials, ErrCrossOriginLink is returned
+// instead. If an error is returned
+// v contains the elements from every page that was successfully
+// decoded.
+func (c *Client) GetAll(ctx context.Context, url string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("GetAll: v must be a non-nil pointer to a slice")
+	}
+	start := url
+	seen := make(map[string]bool)
+	for url != "" {
+		if seen[url] {
+			return fmt.Errorf("GetAll: %s: next link refers to a previous page", url)
+		}
+		seen[url] = true
+		page := reflect.New(rv.Elem().Type())
+		resp, err := c.DoResponse(ctx, "GET", url, "", nil, page.Interface())
+		if err != nil {
+			if resp != nil {
+				// The page was received but could not be decoded.
+				return fmt.Errorf("GetAll: %s: %w", url, err)
+			}
+			return err
+		}
+		rv.Elem().Set(reflect.AppendSlice(rv.Elem(), page.Elem()))
+		next := linkTarget(resp.Header, "next")
+		if next == "" {
+			return nil
+		}
+		if url, err = resolveLink(resp, url, next); err != nil {
+			return fmt.Errorf("GetAll: %w", err)
+		}
+		if err := checkOrigin(start, url); err != nil {
+			return fmt.Errorf("GetAll: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkOrigin returns an error wrapping ErrCrossOriginLink if rawurl is
+// not on the same origin as base.
+func checkOrigin(base, rawurl string) error {
+	u0, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if origin(u) != origin(u0) {
+		return fmt.Errorf("%s: %w", rawurl, ErrCrossOriginLink)
+	}
+	return nil
+}
+
+// origin returns the origin of u, its scheme, host and port, in a form
+// that can be compared.
+func origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// resolveLink resolves the target of a link received in resp, which was
+// retrieved from pageURL.
+func resolveLink(resp *http.Response, pageURL, target string) (string, error) {
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	var base *url.URL
+	if resp.Request != nil && resp.Request.URL != nil {
+		// Use the URL of the final request in case of redirects.
+		base = resp.Request.URL
+	} else if base, err = url.Parse(pageURL); err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// linkTarget returns the target of the first link in the Link headers of
+// h that has the given relation type, or an empty string if there is no
+// such link.
+func linkTarget(h http.Header, rel string) string {
+	for _, v := range h.Values("Link") {
+		for v != "" {
+			v = strings.TrimLeft(v, " \t,")
+			if !strings.HasPrefix(v, "<") {
+				break
+			}
+			end := strings.IndexByte(v, '>')
+			if end < 0 {
+				break
+			}
+			target := v[1:end]
+			var params string
+			params, v = splitLinkParams(v[end+1:])
+			if hasLinkRel(params, rel) {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// splitLinkParams splits s, the text following the target of a link, at
+// the comma that ends the link's parameters, ignoring commas in quoted
+// strings. It returns the parameters and the remaining text.
+func splitLinkParams(s string) (params, rest string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ',':
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// hasLinkRel reports whether the link parameters params contain a rel
+// parameter that includes the relation type rel.
+func hasLinkRel(params, rel string) bool {
+	for _, p := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		for _, r := range strings.Fields(value) {
+			if strings.EqualFold(r, rel) {
+				return true
+			}
+		}
+		// Only the first rel parameter is significant.
+		return false
+	}
+	return false
+}
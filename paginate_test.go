@@ -0,0 +1,390 @@
+package httpjson_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+// offsetHandler serves the given items as a list paginated with the
+// given offset and limit parameters. If pageNumbers is true the offset
+// parameter contains a page number starting from 1.
+func offsetHandler(items []int, offsetParam, limitParam string, pageNumbers bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("filter") != "all" {
+			http.Error(w, "missing filter", http.StatusBadRequest)
+			return
+		}
+		offset, err := strconv.Atoi(req.URL.Query().Get(offsetParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(req.URL.Query().Get(limitParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if pageNumbers {
+			offset = (offset - 1) * limit
+		}
+		page := []int{}
+		for i := offset; i < len(items) && i < offset+limit; i++ {
+			page = append(page, items[i])
+		}
+		httpjson.WriteResponse(w, http.StatusOK, "", page)
+	})
+}
+
+var getAllOffsetTests = []struct {
+	name        string
+	items       []int
+	offsetParam string
+	limitParam  string
+	p           httpjson.OffsetPagination
+	expectError string
+	expectValue []int
+	expectPages [][]int
+}{{
+	name:        "multiple_pages",
+	items:       []int{1, 2, 3, 4, 5, 6, 7},
+	offsetParam: "offset",
+	limitParam:  "limit",
+	p:           httpjson.OffsetPagination{PageSize: 3},
+	expectValue: []int{1, 2, 3, 4, 5, 6, 7},
+	expectPages: [][]int{{1, 2, 3}, {4, 5, 6}, {7}},
+}, {
+	name:        "exact_pages",
+	items:       []int{1, 2, 3, 4},
+	offsetParam: "offset",
+	limitParam:  "limit",
+	p:           httpjson.OffsetPagination{PageSize: 2},
+	expectValue: []int{1, 2, 3, 4},
+	expectPages: [][]int{{1, 2}, {3, 4}, {}},
+}, {
+	name:        "page_numbers",
+	items:       []int{1, 2, 3, 4, 5},
+	offsetParam: "page",
+	limitParam:  "per_page",
+	p: httpjson.OffsetPagination{
+		OffsetParam: "page",
+		LimitParam:  "per_page",
+		PageSize:    2,
+		PageNumbers: true,
+	},
+	expectValue: []int{1, 2, 3, 4, 5},
+	expectPages: [][]int{{1, 2}, {3, 4}, {5}},
+}, {
+	name:        "max_pages",
+	items:       []int{1, 2, 3, 4, 5},
+	offsetParam: "offset",
+	limitParam:  "limit",
+	p:           httpjson.OffsetPagination{PageSize: 2, MaxPages: 2},
+	expectError: `too many pages`,
+	expectValue: []int{1, 2, 3, 4},
+	expectPages: [][]int{{1, 2}, {3, 4}},
+}, {
+	name:        "max_pages_not_reached",
+	items:       []int{1, 2, 3},
+	offsetParam: "offset",
+	limitParam:  "limit",
+	p:           httpjson.OffsetPagination{PageSize: 2, MaxPages: 2},
+	expectValue: []int{1, 2, 3},
+	expectPages: [][]int{{1, 2}, {3}},
+}, {
+	name:        "bad_page_size",
+	offsetParam: "offset",
+	limitParam:  "limit",
+	expectError: `GetAllOffset: PageSize must be greater than zero`,
+}, {
+	name:        "server_error",
+	items:       []int{1, 2, 3},
+	offsetParam: "start",
+	limitParam:  "count",
+	p:           httpjson.OffsetPagination{PageSize: 2},
+	expectError: `strconv.Atoi: parsing "": invalid syntax`,
+}}
+
+func TestGetAllOffset(t *testing.T) {
+	for _, test := range getAllOffsetTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(offsetHandler(test.items, test.offsetParam, test.limitParam, test.p.PageNumbers))
+			defer srv.Close()
+
+			var pages [][]int
+			test.p.OnPage = func(page interface{}) error {
+				pages = append(pages, page.([]int))
+				return nil
+			}
+			var v []int
+			err := new(httpjson.Client).GetAllOffset(context.Background(), srv.URL+"?filter=all", test.p, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+			} else {
+				qt.Check(t, err, qt.IsNil)
+			}
+			qt.Check(t, v, qt.DeepEquals, test.expectValue)
+			qt.Check(t, pages, qt.DeepEquals, test.expectPages)
+		})
+	}
+}
+
+func TestGetAllOffsetOnPageError(t *testing.T) {
+	srv := httptest.NewServer(offsetHandler([]int{1, 2, 3, 4, 5}, "offset", "limit", false))
+	defer srv.Close()
+
+	testErr := errors.New("test error")
+	p := httpjson.OffsetPagination{
+		PageSize: 2,
+		OnPage: func(interface{}) error {
+			return testErr
+		},
+	}
+	var v []int
+	err := new(httpjson.Client).GetAllOffset(context.Background(), srv.URL+"?filter=all", p, &v)
+	qt.Check(t, err, qt.Equals, testErr)
+	qt.Check(t, v, qt.DeepEquals, []int{1, 2})
+}
+
+func TestGetAllOffsetNotSlice(t *testing.T) {
+	var v testValue
+	err := new(httpjson.Client).GetAllOffset(context.Background(), "http://example.com", httpjson.OffsetPagination{PageSize: 1}, &v)
+	qt.Check(t, err, qt.ErrorMatches, `GetAllOffset: v must be a non-nil pointer to a slice`)
+}
+
+// A linkPage is a page of a list paginated with Link headers.
+type linkPage struct {
+	link        []string
+	contentType string
+	body        string
+}
+
+// linkHandler serves the given pages, keyed by path.
+func linkHandler(pages map[string]linkPage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := pages[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header()["Link"] = p.link
+		w.Header().Set("Content-Type", p.contentType)
+		w.Write([]byte(p.body))
+	})
+}
+
+var getAllTests = []struct {
+	name        string
+	pages       map[string]linkPage
+	expectError string
+	expectValue []string
+}{{
+	name: "relative_links",
+	pages: map[string]linkPage{
+		"/": {
+			link:        []string{`</page/2>; rel="next"`},
+			contentType: "application/json",
+			body:        `["a","b"]`,
+		},
+		"/page/2": {
+			link:        []string{`<3>; rel=next`},
+			contentType: "application/json",
+			body:        `["c"]`,
+		},
+		"/page/3": {
+			link:        []string{`</>; rel="first"`},
+			contentType: "application/json",
+			body:        `["d"]`,
+		},
+	},
+	expectValue: []string{"a", "b", "c", "d"},
+}, {
+	name: "multiple_links",
+	pages: map[string]linkPage{
+		"/": {
+			link: []string{
+				`</?a=1,2>; rel="first"; title="a, b", </last>; rel="last"`,
+				`</next>; rel="prefetch next"`,
+			},
+			contentType: "application/json",
+			body:        `["a"]`,
+		},
+		"/next": {
+			contentType: "application/json",
+			body:        `["b"]`,
+		},
+	},
+	expectValue: []string{"a", "b"},
+}, {
+	name: "charsets",
+	pages: map[string]linkPage{
+		"/": {
+			link:        []string{`</2>; rel="next"`},
+			contentType: "application/json;charset=iso-8859-1",
+			body:        "[\"\xa3\"]",
+		},
+		"/2": {
+			contentType: "application/json;charset=utf-16le",
+			body:        "[\x00\"\x00\x3a\x26\"\x00]\x00",
+		},
+	},
+	expectValue: []string{"£", "☺"},
+}, {
+	name: "decode_error",
+	pages: map[string]linkPage{
+		"/": {
+			link:        []string{`</2>; rel="next"`},
+			contentType: "application/json",
+			body:        `["a"]`,
+		},
+		"/2": {
+			contentType: "application/json",
+			body:        `{"s":"b"}`,
+		},
+	},
+	expectError: `GetAll: http://.*/2: .*cannot unmarshal object into Go value of type \[\]string.*`,
+	expectValue: []string{"a"},
+}, {
+	name: "loop",
+	pages: map[string]linkPage{
+		"/": {
+			link:        []string{`</2>; rel="next"`},
+			contentType: "application/json",
+			body:        `["a"]`,
+		},
+		"/2": {
+			link:        []string{`</>; rel="next"`},
+			contentType: "application/json",
+			body:        `["b"]`,
+		},
+	},
+	expectError: `GetAll: http://.*/: next link refers to a previous page`,
+	expectValue: []string{"a", "b"},
+}, {
+	name: "not_found",
+	pages: map[string]linkPage{
+		"/": {
+			link:        []string{`</missing>; rel="next"`},
+			contentType: "application/json",
+			body:        `["a"]`,
+		},
+	},
+	expectError: `404 page not found`,
+	expectValue: []string{"a"},
+}}
+
+func TestGetAll(t *testing.T) {
+	for _, test := range getAllTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(linkHandler(test.pages))
+			defer srv.Close()
+
+			var v []string
+			err := new(httpjson.Client).GetAll(context.Background(), srv.URL+"/", &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+			} else {
+				qt.Check(t, err, qt.IsNil)
+			}
+			qt.Check(t, v, qt.DeepEquals, test.expectValue)
+		})
+	}
+}
+
+func TestGetAllCrossOrigin(t *testing.T) {
+	var other []string
+	osrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		other = append(other, req.Header.Get("Authorization"))
+		httpjson.WriteResponse(w, http.StatusOK, "", []string{"stolen"})
+	}))
+	defer osrv.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", "<"+osrv.URL+`/next>; rel="next"`)
+		httpjson.WriteResponse(w, http.StatusOK, "", []string{"a"})
+	}))
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		BearerToken: func(context.Context) (string, error) {
+			return "secret", nil
+		},
+	}
+	var v []string
+	err := cl.GetAll(context.Background(), srv.URL, &v)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrCrossOriginLink)
+	qt.Check(t, err, qt.ErrorMatches, `GetAll: http://.*/next: link to a different origin`)
+	qt.Check(t, v, qt.DeepEquals, []string{"a"})
+	qt.Check(t, other, qt.HasLen, 0)
+}
+
+var checkOriginTests = []struct {
+	name        string
+	url         string
+	link        string
+	expectError bool
+}{{
+	name: "same",
+	url:  "http://example.com/a",
+	link: "http://example.com/b?page=2",
+}, {
+	name: "default_port",
+	url:  "https://Example.com/a",
+	link: "https://example.com:443/b",
+}, {
+	name:        "host",
+	url:         "https://example.com/a",
+	link:        "https://example.org/a",
+	expectError: true,
+}, {
+	name:        "scheme",
+	url:         "https://example.com/a",
+	link:        "http://example.com/a",
+	expectError: true,
+}, {
+	name:        "port",
+	url:         "https://example.com/a",
+	link:        "https://example.com:8443/a",
+	expectError: true,
+}}
+
+func TestCheckOrigin(t *testing.T) {
+	for _, test := range checkOriginTests {
+		t.Run(test.name, func(t *testing.T) {
+			err := httpjson.CheckOrigin(test.url, test.link)
+			if test.expectError {
+				qt.Check(t, err, qt.ErrorIs, httpjson.ErrCrossOriginLink)
+			} else {
+				qt.Check(t, err, qt.IsNil)
+			}
+		})
+	}
+}
+
+func TestGetAllContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Cancel the context once the first page has been requested.
+		cancel()
+		w.Header().Set("Link", `</next>; rel="next"`)
+		httpjson.WriteResponse(w, http.StatusOK, "", []string{"a"})
+	}))
+	defer srv.Close()
+
+	var v []string
+	err := new(httpjson.Client).GetAll(ctx, srv.URL, &v)
+	qt.Check(t, err, qt.ErrorIs, context.Canceled)
+}
+
+func TestGetAllNotSlice(t *testing.T) {
+	var v testValue
+	err := new(httpjson.Client).GetAll(context.Background(), "http://example.com", &v)
+	qt.Check(t, err, qt.ErrorMatches, `GetAll: v must be a non-nil pointer to a slice`)
+}
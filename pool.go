@@ -0,0 +1,133 @@
+package httpjson
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which buffers are not returned to
+// the pool, so that an occasional large value doesn't pin a large
+// allocation.
+const maxPooledBuffer = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer gets an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool. The caller must not use buf again.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// A pooledBody provides request bodies that read from a pooled buffer.
+// The buffer is returned to the pool once release has been called and
+// every body that was created has been closed, so that the transport
+// can safely read the body, or request a new one through GetBody, until
+// the request has completed.
+type pooledBody struct {
+	mu   sync.Mutex
+	buf  *bytes.Buffer
+	open int
+	done bool
+}
+
+// errBodyReleased is the error returned by the GetBody function of a
+// request with a pooled body once the request has completed.
+var errBodyReleased = errors.New("request body has been released")
+
+// newBody creates a new request body reading from the buffer.
+func (b *pooledBody) newBody() io.ReadCloser {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.newBodyLocked()
+}
+
+// newBodyLocked creates a new request body, it must be called with b.mu
+// held.
+func (b *pooledBody) newBodyLocked() io.ReadCloser {
+	b.open++
+	return &pooledBodyReader{
+		Reader: bytes.NewReader(b.buf.Bytes()),
+		body:   b,
+	}
+}
+
+// getBody implements the GetBody function of an http.Request. Once the
+// request has completed the buffer may have been returned to the pool,
+// so no more bodies can be created and an error is returned instead.
+func (b *pooledBody) getBody() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return nil, errBodyReleased
+	}
+	return b.newBodyLocked(), nil
+}
+
+// release indicates that the request has completed and no more bodies
+// will be created.
+func (b *pooledBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.put()
+}
+
+// closeBody records that a body created by newBody has been closed.
+func (b *pooledBody) closeBody() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open--
+	b.put()
+}
+
+// put returns the buffer to the pool if it is no longer in use. put must
+// be called with b.mu held.
+func (b *pooledBody) put() {
+	if !b.done || b.open > 0 || b.buf == nil {
+		return
+	}
+	putBuffer(b.buf)
+	b.buf = nil
+}
+
+// A pooledBodyReader is a request body created by a pooledBody.
+type pooledBodyReader struct {
+	*bytes.Reader
+	body *pooledBody
+	once sync.Once
+}
+
+// Close implements io.Closer.
+func (r *pooledBodyReader) Close() error {
+	r.once.Do(r.body.closeBody)
+	return nil
+}
+
+// A releaseCloser closes an io.ReadCloser and then releases the pooled
+// request body that produced the response.
+type releaseCloser struct {
+	io.ReadCloser
+	body *pooledBody
+	once sync.Once
+}
+
+// Close implements io.Closer.
+func (r *releaseCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.body.release)
+	return err
+}
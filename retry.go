@@ -0,0 +1,204 @@
+package httpjson
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy determines how a Client retries requests that fail with
+// a transient error. Only requests with an idempotent method (GET,
+// HEAD, OPTIONS, TRACE, PUT and DELETE), or with an Idempotency-Key or
+// X-Idempotency-Key header, are retried. A request is retried when
+// sending it times out, when the connection is refused or reset, or
+// closed before a response is received, or when the server responds
+// with 429 Too Many Requests or 503 Service Unavailable. Other errors,
+// such as an invalid TLS certificate or an error from the http.Client's
+// CheckRedirect function, are not retried.
+//
+// The delay before each retry doubles, starting at InitialDelay and
+// limited to MaxDelay. If a response contains a valid Retry-After
+// header then the delay it specifies, measured from when the response
+// was received, is used instead. A request is not retried if the delay
+// would extend beyond the deadline of the request's context, or if a
+// Retry-After delay exceeds MaxRetryAfter, in which case the last
+// response, or error, is returned.
+// A request with a body is only retried if the body can be replayed
+// using the request's GetBody function.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent,
+	// including the first attempt. If this is zero 3 attempts are
+	// made.
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first retry. If this is
+	// zero a delay of 100ms is used.
+	InitialDelay time.Duration
+
+	// MaxDelay is the maximum delay between attempts calculated by
+	// the exponential backoff. It does not limit a delay requested
+	// with a Retry-After header. If this is zero a maximum of 10s is
+	// used.
+	MaxDelay time.Duration
+
+	// MaxRetryAfter is the longest delay requested by a Retry-After
+	// header that will be waited for. If a response requests a longer
+	// delay it is returned without the request being retried. If this
+	// is zero a maximum of one minute is used.
+	MaxRetryAfter time.Duration
+}
+
+const (
+	defaultRetryAttempts     = 3
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay     = 10 * time.Second
+	defaultRetryMaxAfter     = time.Minute
+)
+
+// maxRetryDrain is the maximum number of bytes of the body of a
+// response that will be discarded before retrying, so that the
+// connection can be reused.
+const maxRetryDrain = 4096
+
+// do sends req using client, retrying according to the policy.
+func (p *RetryPolicy) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if !idempotent(req) || (hasBody && req.GetBody == nil) {
+		return client.Do(req)
+	}
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= attempts || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+		delay := p.delay(attempt)
+		if resp != nil {
+			if d, ok := retryDelay(resp.Header); ok {
+				if d > p.maxRetryAfter() {
+					return resp, err
+				}
+				delay = d
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+		var body io.ReadCloser
+		if hasBody {
+			body, err = req.GetBody()
+			if err != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				return nil, err
+			}
+		}
+		if resp != nil {
+			io.CopyN(io.Discard, resp.Body, maxRetryDrain)
+			resp.Body.Close()
+		}
+		if err := sleep(ctx, delay); err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		if hasBody {
+			req1 := *req
+			req1.Body = body
+			req = &req1
+		}
+	}
+}
+
+// delay calculates the backoff delay after the given attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.InitialDelay
+	if d <= 0 {
+		d = defaultRetryInitialDelay
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// maxRetryAfter returns the longest Retry-After delay that will be
+// waited for.
+func (p *RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter > 0 {
+		return p.MaxRetryAfter
+	}
+	return defaultRetryMaxAfter
+}
+
+// idempotent determines whether req can safely be sent more than once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// retryable determines whether the result of sending a request
+// indicates a transient failure that is worth retrying.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		// Every error returned by http.Client.Do is a *url.Error,
+		// which is itself a net.Error, so examine the error it wraps.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return true
+		}
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// sleep waits for d, returning early with the context's error if ctx is
+// done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
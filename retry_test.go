@@ -0,0 +1,379 @@
+package httpjson_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+// failingHandler responds with the given status to the first failures
+// requests, then echoes the request.
+type failingHandler struct {
+	status   int
+	failures int32
+	header   http.Header
+	requests int32
+	bodies   []string
+}
+
+func (h *failingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := atomic.AddInt32(&h.requests, 1)
+	body, _ := io.ReadAll(req.Body)
+	h.bodies = append(h.bodies, string(body))
+	if n <= h.failures {
+		for k, v := range h.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(h.status)
+		w.Write([]byte("try again"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) == 0 {
+		body = []byte(`{"s":"ok"}`)
+	}
+	w.Write(body)
+}
+
+var clientRetryTests = []struct {
+	name         string
+	method       string
+	status       int
+	failures     int32
+	header       http.Header
+	policy       *httpjson.RetryPolicy
+	expectError  string
+	expectCalls  int32
+	expectStatus int
+}{{
+	name:        "no_policy",
+	method:      "GET",
+	status:      http.StatusServiceUnavailable,
+	failures:    1,
+	expectError: `try again`,
+	expectCalls: 1,
+}, {
+	name:        "service_unavailable",
+	method:      "GET",
+	status:      http.StatusServiceUnavailable,
+	failures:    2,
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectCalls: 3,
+}, {
+	name:        "too_many_requests",
+	method:      "DELETE",
+	status:      http.StatusTooManyRequests,
+	failures:    1,
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectCalls: 2,
+}, {
+	name:        "put",
+	method:      "PUT",
+	status:      http.StatusServiceUnavailable,
+	failures:    2,
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectCalls: 3,
+}, {
+	name:        "attempts_exhausted",
+	method:      "GET",
+	status:      http.StatusServiceUnavailable,
+	failures:    5,
+	policy:      &httpjson.RetryPolicy{MaxAttempts: 4, InitialDelay: time.Millisecond},
+	expectError: `try again`,
+	expectCalls: 4,
+}, {
+	name:        "not_idempotent",
+	method:      "POST",
+	status:      http.StatusServiceUnavailable,
+	failures:    1,
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectError: `try again`,
+	expectCalls: 1,
+}, {
+	name:        "idempotency_key",
+	method:      "POST",
+	status:      http.StatusServiceUnavailable,
+	failures:    1,
+	header:      http.Header{"Idempotency-Key": {"1234"}},
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectCalls: 2,
+}, {
+	name:        "not_transient",
+	method:      "GET",
+	status:      http.StatusInternalServerError,
+	failures:    1,
+	policy:      &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	expectError: `try again`,
+	expectCalls: 1,
+}}
+
+func TestClientRetry(t *testing.T) {
+	for _, test := range clientRetryTests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &failingHandler{
+				status:   test.status,
+				failures: test.failures,
+			}
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			cl := httpjson.Client{Retry: test.policy}
+			ctx := httpjson.ContextWithHeader(context.Background(), test.header)
+			var req interface{}
+			if test.method == "PUT" || test.method == "POST" {
+				req = testValue{S: "body"}
+			}
+			var resp testValue
+			err := cl.Do(ctx, test.method, srv.URL, "", req, &resp)
+			qt.Check(t, h.requests, qt.Equals, test.expectCalls)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			if req != nil {
+				qt.Check(t, resp.S, qt.Equals, "body")
+				for _, body := range h.bodies {
+					qt.Check(t, body, qt.Equals, `{"s":"body"}`)
+				}
+			} else {
+				qt.Check(t, resp.S, qt.Equals, "ok")
+			}
+		})
+	}
+}
+
+func TestClientRetryPoolRequestBodies(t *testing.T) {
+	h := &failingHandler{
+		status:   http.StatusServiceUnavailable,
+		failures: 2,
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		PoolRequestBodies: true,
+		Retry:             &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	}
+	var resp testValue
+	err := cl.Put(context.Background(), srv.URL, testValue{S: "body"}, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "body")
+	qt.Check(t, h.bodies, qt.DeepEquals, []string{`{"s":"body"}`, `{"s":"body"}`, `{"s":"body"}`})
+}
+
+func TestClientRetryAfterBeyondDeadline(t *testing.T) {
+	h := &failingHandler{
+		status:   http.StatusTooManyRequests,
+		failures: 1,
+		header:   http.Header{"Retry-After": {"3600"}},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var resp testValue
+	err := cl.Get(ctx, srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorMatches, `try again`)
+	qt.Check(t, errors.Is(err, httpjson.ErrTooManyRequests), qt.IsTrue)
+	qt.Check(t, h.requests, qt.Equals, int32(1))
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	h := &failingHandler{
+		status:   http.StatusServiceUnavailable,
+		failures: 1,
+		header:   http.Header{"Retry-After": {"0"}},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// The Retry-After header replaces the hour long backoff.
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Hour},
+	}
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "ok")
+	qt.Check(t, h.requests, qt.Equals, int32(2))
+}
+
+func TestClientRetryContextCanceled(t *testing.T) {
+	h := &failingHandler{
+		status:   http.StatusServiceUnavailable,
+		failures: 1,
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Hour},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var resp testValue
+	err := cl.Get(ctx, srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorIs, context.Canceled)
+	qt.Check(t, h.requests, qt.Equals, int32(1))
+}
+
+func TestClientRetryNetworkError(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: "ok"})
+	}))
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	}
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "ok")
+	qt.Check(t, requests, qt.Equals, int32(2))
+}
+
+var retryErrorTests = []struct {
+	name        string
+	err         error
+	expectCalls int32
+}{{
+	name:        "connection_refused",
+	err:         &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+	expectCalls: 3,
+}, {
+	name:        "connection_reset",
+	err:         &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+	expectCalls: 3,
+}, {
+	name:        "eof",
+	err:         io.EOF,
+	expectCalls: 3,
+}, {
+	name:        "timeout",
+	err:         &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+	expectCalls: 3,
+}, {
+	name:        "certificate",
+	err:         x509.UnknownAuthorityError{},
+	expectCalls: 1,
+}, {
+	name:        "other",
+	err:         errors.New("unsupported protocol"),
+	expectCalls: 1,
+}}
+
+func TestClientRetryError(t *testing.T) {
+	for _, test := range retryErrorTests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			cl := httpjson.Client{
+				HTTPClient: &http.Client{
+					Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						calls++
+						return nil, test.err
+					}),
+				},
+				Retry: &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+			}
+			err := cl.Get(context.Background(), "http://example.com", nil)
+			qt.Check(t, err, qt.ErrorIs, test.err)
+			qt.Check(t, calls, qt.Equals, test.expectCalls)
+		})
+	}
+}
+
+func TestClientRetryCertificateError(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpjson.WriteResponse(w, http.StatusOK, "", testValue{S: "ok"})
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The default client does not trust the test server's certificate.
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	}
+	err := cl.Get(context.Background(), srv.URL, nil)
+	qt.Check(t, err, qt.ErrorMatches, `.*certificate.*`)
+	qt.Check(t, atomic.LoadInt32(&conns), qt.Equals, int32(1))
+}
+
+func TestClientRetryCheckRedirectError(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Redirect(w, req, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	redirectErr := errors.New("redirect not allowed")
+	cl := httpjson.Client{
+		HTTPClient: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return redirectErr
+			},
+		},
+		Retry: &httpjson.RetryPolicy{InitialDelay: time.Millisecond},
+	}
+	err := cl.Get(context.Background(), srv.URL, nil)
+	qt.Check(t, err, qt.ErrorIs, redirectErr)
+	qt.Check(t, requests, qt.Equals, int32(1))
+}
+
+func TestClientRetryAfterBeyondMax(t *testing.T) {
+	h := &failingHandler{
+		status:   http.StatusServiceUnavailable,
+		failures: 1,
+		header:   http.Header{"Retry-After": {"2"}},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cl := httpjson.Client{
+		Retry: &httpjson.RetryPolicy{
+			InitialDelay:  time.Millisecond,
+			MaxRetryAfter: time.Second,
+		},
+	}
+	start := time.Now()
+	err := cl.Get(context.Background(), srv.URL, nil)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrServiceUnavailable)
+	qt.Check(t, h.requests, qt.Equals, int32(1))
+	qt.Check(t, time.Since(start) < time.Second, qt.IsTrue)
+}
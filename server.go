@@ -0,0 +1,161 @@
+package httpjson
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Handle returns an http.Handler that calls fn with the JSON-encoded
+// body of each request and writes the value it returns as a JSON
+// response.
+//
+// The request body is decoded into a Req using UnmarshalRequest, a
+// request without a body leaves the Req as its zero value. The body is
+// limited to 1MiB, see HandleOptions to change the limit. If the body
+// cannot be decoded the handler responds with 400 Bad Request, or 413
+// Request Entity Too Large if the error is ErrRequestTooLarge, using
+// WriteError, without calling fn. The value returned by a successful
+// call to fn is written using WriteResponse with the status 200 OK.
+//
+// If fn returns an error that is, or wraps, a *ResponseError with a
+// Response then the status code and body of the error's response are
+// written, so that an error from an upstream service can be passed on.
+// An error that is, or wraps, a StatusError results in an error
+// response, written using WriteError, with that status code. Any other
+// error results in a 500 Internal Server Error response. In both cases
+// the message of the error returned by fn is not sent to the client,
+// the message is the text of the status code.
+func Handle[Req, Resp any](fn func(context.Context, Req) (Resp, error)) http.Handler {
+	return HandleOptions(UnmarshalOptions{MaxBodyBytes: defaultMaxHandleBytes}, fn)
+}
+
+// defaultMaxHandleBytes is the maximum size of a request body decoded by
+// a Handle handler.
+const defaultMaxHandleBytes = 1 << 20
+
+// HandleOptions returns an http.Handler that calls fn in the same way as
+// Handle, decoding request bodies with the options in o. The size of the
+// body is limited by o.MaxBodyBytes.
+func HandleOptions[Req, Resp any](o UnmarshalOptions, fn func(context.Context, Req) (Resp, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var v Req
+		if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+			if err := o.UnmarshalRequest(req, &v); err != nil {
+				code := http.StatusBadRequest
+				if errors.Is(err, ErrRequestTooLarge) {
+					code = http.StatusRequestEntityTooLarge
+				}
+				WriteError(w, code, err)
+				return
+			}
+		}
+		resp, err := fn(req.Context(), v)
+		if err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		WriteResponse(w, http.StatusOK, "", resp)
+	})
+}
+
+// writeHandlerError writes the response for an error returned from the
+// function called by a Handle handler.
+func writeHandlerError(w http.ResponseWriter, err error) {
+	var rerr *ResponseError
+	if errors.As(err, &rerr) && rerr.Response != nil {
+		MarshalOptions{}.writeBody(w, rerr.StatusCode(), rerr.Response.Header.Get("Content-Type"), rerr.Body)
+		return
+	}
+	code := http.StatusInternalServerError
+	var serr StatusError
+	if errors.As(err, &serr) {
+		code = int(serr)
+	}
+	WriteError(w, code, errors.New(http.StatusText(code)))
+}
+
+// An ErrorCoder is an error that has a machine readable code, which is
+// included in the body written by WriteError.
+type ErrorCoder interface {
+	error
+
+	// ErrorCode returns the code identifying the error.
+	ErrorCode() string
+}
+
+// An ErrorDetailer is an error that has additional details, which are
+// included in the body written by WriteError.
+type ErrorDetailer interface {
+	error
+
+	// ErrorDetails returns a value, which must be JSON encodable,
+	// describing the error in more detail.
+	ErrorDetails() interface{}
+}
+
+// errorBody is the body of a response written by WriteError.
+type errorBody struct {
+	Error   string      `json:"error"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// WriteError writes a JSON error response with the given status code.
+// The body is a JSON object with an "error" member containing the
+// message of err, for example:
+//
+//	{"error":"item not found"}
+//
+// If err is, or wraps, an ErrorCoder the object also has a "code" member
+// containing its code, and if err is, or wraps, an ErrorDetailer it has
+// a "details" member containing its details. The response is written
+// using WriteResponse with the content type
+// "application/json;charset=utf-8", so the Content-Type and
+// Content-Length headers are set.
+func WriteError(w http.ResponseWriter, statusCode int, err error) error {
+	body := errorBody{Error: err.Error()}
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		body.Code = coder.ErrorCode()
+	}
+	var detailer ErrorDetailer
+	if errors.As(err, &detailer) {
+		body.Details = detailer.ErrorDetails()
+	}
+	return WriteResponse(w, statusCode, "application/json;charset=utf-8", body)
+}
+
+// ResponseHandler returns an http.Handler that responds to every request
+// by writing v using WriteResponse with the given status code and
+// content type, so the body is encoded into the character set of the
+// content type exactly as a real handler using this package would. It
+// is intended for tests of code that calls a JSON API, for example:
+//
+//	srv := httptest.NewServer(httpjson.ResponseHandler(http.StatusOK, "application/json;charset=iso-8859-1", v))
+//	defer srv.Close()
+//
+// If v cannot be encoded the handler responds with 500 Internal Server
+// Error, using WriteError.
+func ResponseHandler(statusCode int, contentType string, v interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if v == nil {
+			WriteResponse(w, statusCode, contentType, v)
+			return
+		}
+		// Encode the body before writing anything so that an error
+		// response can still be written if it fails.
+		ct := MarshalOptions{}.valueContentType(contentType, v)
+		charset, err := contentCharset(ct)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		body, err := MarshalOptions{}.marshal(charset, v)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		MarshalOptions{}.writeBody(w, statusCode, ct, body)
+	})
+}
@@ -0,0 +1,383 @@
+package httpjson_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+var handleTests = []struct {
+	name              string
+	method            string
+	contentType       string
+	body              string
+	fn                func(context.Context, testValue) (testValue, error)
+	expectStatusCode  int
+	expectContentType string
+	expectBody        string
+}{{
+	name:   "success",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		v.S = "hello " + v.S
+		return v, nil
+	},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"hello ☺"}`,
+}, {
+	name:        "charset",
+	method:      "POST",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        "{\"s\":\"\xa3\"}",
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return v, nil
+	},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"£"}`,
+}, {
+	name:   "no_body",
+	method: "GET",
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{S: "empty " + v.S}, nil
+	},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"empty "}`,
+}, {
+	name:   "bad_request",
+	method: "POST",
+	body:   `{"s":`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		panic("unexpected call")
+	},
+	expectStatusCode:  http.StatusBadRequest,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"unexpected end of JSON input (offset 5 near \"{\\\"s\\\":\")"}`,
+}, {
+	name:   "error",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{}, errors.New("secret internal error")
+	},
+	expectStatusCode:  http.StatusInternalServerError,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"Internal Server Error"}`,
+}, {
+	name:   "status_error",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{}, fmt.Errorf("cannot find %q: %w", v.S, httpjson.ErrNotFound)
+	},
+	expectStatusCode:  http.StatusNotFound,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"Not Found"}`,
+}, {
+	name:   "response_error",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{}, fmt.Errorf("upstream: %w", &httpjson.ResponseError{
+			Response: &http.Response{
+				StatusCode: http.StatusConflict,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+			},
+			Body: []byte(`{"error":"conflict"}`),
+		})
+	},
+	expectStatusCode:  http.StatusConflict,
+	expectContentType: "application/json",
+	expectBody:        `{"error":"conflict"}`,
+}, {
+	name:   "response_error_no_response",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{}, &httpjson.ResponseError{Err: httpjson.ErrNotFound}
+	},
+	expectStatusCode:  http.StatusNotFound,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"Not Found"}`,
+}, {
+	name:   "response_error_no_response_or_status",
+	method: "POST",
+	body:   `{"s":"☺"}`,
+	fn: func(_ context.Context, v testValue) (testValue, error) {
+		return testValue{}, &httpjson.ResponseError{}
+	},
+	expectStatusCode:  http.StatusInternalServerError,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"Internal Server Error"}`,
+}}
+
+func TestHandle(t *testing.T) {
+	for _, test := range handleTests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			req := httptest.NewRequest(test.method, "/", body)
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			rr := httptest.NewRecorder()
+			httpjson.Handle(test.fn).ServeHTTP(rr, req)
+			resp := rr.Result()
+			qt.Check(t, resp.StatusCode, qt.Equals, test.expectStatusCode)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			buf, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestHandleTooLarge(t *testing.T) {
+	h := httpjson.Handle(func(_ context.Context, v testValue) (testValue, error) {
+		panic("unexpected call")
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"s":"`+strings.Repeat("a", 1<<20)+`"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	resp := rr.Result()
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusRequestEntityTooLarge)
+	buf, err := io.ReadAll(resp.Body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(buf), qt.Equals, `{"error":"request body too large"}`)
+}
+
+var handleOptionsTests = []struct {
+	name             string
+	opts             httpjson.UnmarshalOptions
+	body             string
+	expectStatusCode int
+	expectBody       string
+}{{
+	name:             "within_limit",
+	opts:             httpjson.UnmarshalOptions{MaxBodyBytes: 16},
+	body:             `{"s":"a"}`,
+	expectStatusCode: http.StatusOK,
+	expectBody:       `{"s":"a"}`,
+}, {
+	name:             "too_large",
+	opts:             httpjson.UnmarshalOptions{MaxBodyBytes: 16},
+	body:             `{"s":"aaaaaaaaaaaaaaaa"}`,
+	expectStatusCode: http.StatusRequestEntityTooLarge,
+	expectBody:       `{"error":"request body too large"}`,
+}, {
+	name:             "unknown_field",
+	opts:             httpjson.UnmarshalOptions{DisallowUnknownFields: true},
+	body:             `{"s":"a","t":"b"}`,
+	expectStatusCode: http.StatusBadRequest,
+	expectBody:       `{"error":"json: unknown field \"t\""}`,
+}}
+
+func TestHandleOptions(t *testing.T) {
+	for _, test := range handleOptionsTests {
+		t.Run(test.name, func(t *testing.T) {
+			h := httpjson.HandleOptions(test.opts, func(_ context.Context, v testValue) (testValue, error) {
+				return v, nil
+			})
+			req := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+			qt.Check(t, resp.StatusCode, qt.Equals, test.expectStatusCode)
+			buf, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestHandleClient(t *testing.T) {
+	srv := httptest.NewServer(httpjson.Handle(func(_ context.Context, req []int) (int, error) {
+		if len(req) == 0 {
+			return 0, httpjson.ErrUnprocessableEntity
+		}
+		sum := 0
+		for _, n := range req {
+			sum += n
+		}
+		return sum, nil
+	}))
+	defer srv.Close()
+
+	var sum int
+	err := httpjson.Post(context.Background(), srv.URL, []int{1, 2, 3}, &sum)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, sum, qt.Equals, 6)
+
+	err = httpjson.Post(context.Background(), srv.URL, []int{}, &sum)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrUnprocessableEntity)
+}
+
+// codedError is an error with a code and details.
+type codedError struct {
+	code    string
+	details interface{}
+}
+
+func (e codedError) Error() string {
+	return "coded error"
+}
+
+func (e codedError) ErrorCode() string {
+	return e.code
+}
+
+func (e codedError) ErrorDetails() interface{} {
+	return e.details
+}
+
+var writeErrorTests = []struct {
+	name       string
+	statusCode int
+	err        error
+	expectBody string
+}{{
+	name:       "message",
+	statusCode: http.StatusNotFound,
+	err:        errors.New("item \"☺\" not found"),
+	expectBody: `{"error":"item \"☺\" not found"}`,
+}, {
+	name:       "code",
+	statusCode: http.StatusConflict,
+	err:        codedError{code: "conflict"},
+	expectBody: `{"error":"coded error","code":"conflict"}`,
+}, {
+	name:       "details",
+	statusCode: http.StatusUnprocessableEntity,
+	err:        fmt.Errorf("invalid: %w", codedError{code: "invalid", details: map[string]string{"name": "required"}}),
+	expectBody: `{"error":"invalid: coded error","code":"invalid","details":{"name":"required"}}`,
+}}
+
+func TestWriteError(t *testing.T) {
+	for _, test := range writeErrorTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := httpjson.WriteError(rr, test.statusCode, test.err)
+			qt.Assert(t, err, qt.IsNil)
+			resp := rr.Result()
+			qt.Check(t, resp.StatusCode, qt.Equals, test.statusCode)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/json;charset=utf-8")
+			buf, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+			qt.Check(t, int(resp.ContentLength), qt.Equals, len(test.expectBody))
+		})
+	}
+}
+
+func TestWriteErrorResponseError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpjson.WriteError(w, http.StatusForbidden, codedError{code: "denied"})
+	}))
+	defer srv.Close()
+
+	err := httpjson.Get(context.Background(), srv.URL, nil)
+	qt.Assert(t, err, qt.ErrorIs, httpjson.ErrForbidden)
+	var rerr *httpjson.ResponseError
+	qt.Assert(t, errors.As(err, &rerr), qt.IsTrue)
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	err = rerr.Decode(&body)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, body.Error, qt.Equals, "coded error")
+	qt.Check(t, body.Code, qt.Equals, "denied")
+}
+
+var responseHandlerTests = []struct {
+	name              string
+	statusCode        int
+	contentType       string
+	v                 interface{}
+	expectStatusCode  int
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "default_content_type",
+	statusCode:        http.StatusOK,
+	v:                 testValue{S: "☺"},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "status_code",
+	statusCode:        http.StatusCreated,
+	contentType:       "application/json",
+	v:                 testValue{S: "created"},
+	expectStatusCode:  http.StatusCreated,
+	expectContentType: "application/json",
+	expectBody:        `{"s":"created"}`,
+}, {
+	name:              "iso-8859-1",
+	statusCode:        http.StatusOK,
+	contentType:       "application/json;charset=iso-8859-1",
+	v:                 testValue{S: "£"},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}",
+}, {
+	name:              "content_typer",
+	statusCode:        http.StatusOK,
+	v:                 latin1Value{S: "£"},
+	expectStatusCode:  http.StatusOK,
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}",
+}, {
+	name:             "no_body",
+	statusCode:       http.StatusAccepted,
+	expectStatusCode: http.StatusAccepted,
+}, {
+	name:              "unencodable",
+	statusCode:        http.StatusOK,
+	v:                 make(chan int),
+	expectStatusCode:  http.StatusInternalServerError,
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"error":"json: unsupported type: chan int"}`,
+}}
+
+func TestResponseHandler(t *testing.T) {
+	for _, test := range responseHandlerTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(httpjson.ResponseHandler(test.statusCode, test.contentType, test.v))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			qt.Assert(t, err, qt.IsNil)
+			defer resp.Body.Close()
+			qt.Check(t, resp.StatusCode, qt.Equals, test.expectStatusCode)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			buf, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestResponseHandlerClient(t *testing.T) {
+	srv := httptest.NewServer(httpjson.ResponseHandler(http.StatusOK, "application/json;charset=iso-8859-1", testValue{S: "£"}))
+	defer srv.Close()
+
+	var v testValue
+	err := httpjson.Get(context.Background(), srv.URL, &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v, qt.Equals, testValue{S: "£"})
+}
@@ -0,0 +1,465 @@
+package httpjson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// ErrTooManyElements is the error returned by DecodeArrayLimit when an
+// array contains more elements than the limit.
+var ErrTooManyElements = errors.New("too many array elements")
+
+// An ArrayLimitPolicy determines the behaviour of DecodeArrayLimit when an
+// array contains more elements than the limit.
+type ArrayLimitPolicy int
+
+const (
+	// ArrayLimitError causes DecodeArrayLimit to return
+	// ErrTooManyElements when the limit is exceeded.
+	ArrayLimitError ArrayLimitPolicy = iota
+
+	// ArrayLimitTruncate causes DecodeArrayLimit to stop decoding once
+	// the limit has been reached, discarding any remaining elements.
+	ArrayLimitTruncate
+)
+
+// DecodeArrayLimit parses a JSON array from the body of an http.Response
+// and stores the elements in the slice pointed to by v. At most limit
+// elements are decoded, an array with more elements is handled according
+// to policy. The elements are decoded one at a time from the body, so an
+// oversized array is rejected without the whole body being read.
+//
+// DecodeArrayLimit decodes the response body from the character set
+// specified in the response's Content-Type header before parsing the
+// JSON value. A JSON null sets the slice to nil. The slice is only
+// modified if the array is successfully decoded. A body that ends before
+// the end of the array results in io.ErrUnexpectedEOF.
+func DecodeArrayLimit(resp *http.Response, v interface{}, limit int, policy ArrayLimitPolicy) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("DecodeArrayLimit: v must be a non-nil pointer to a slice")
+	}
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	r, err := charsetReader(resp.Body, charset)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return errors.New("DecodeArrayLimit: value is not an array")
+	}
+	slice := reflect.MakeSlice(rv.Elem().Type(), 0, 0)
+	for dec.More() {
+		if slice.Len() >= limit {
+			if policy == ArrayLimitTruncate {
+				rv.Elem().Set(slice)
+				return nil
+			}
+			return ErrTooManyElements
+		}
+		elem := reflect.New(slice.Type().Elem())
+		if err := dec.Decode(elem.Interface()); err != nil {
+			return unexpectedEOF(err)
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+	if _, err := dec.Token(); err != nil {
+		return unexpectedEOF(err)
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF if err, returned by a
+// json.Decoder part way through a value, was caused by the end of the
+// input. Depending on the version of Go, encoding/json reports this as
+// io.EOF or as a *json.SyntaxError.
+func unexpectedEOF(err error) error {
+	var serr *json.SyntaxError
+	if err == io.EOF || errors.As(err, &serr) && serr.Error() == "unexpected end of JSON input" {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// DecodeResponsePath parses the JSON value at the given path in the body
+// of an http.Response and calls fn with the value. The path is a list of
+// object keys separated by dots, such as "data.items", optionally
+// followed by "[]". If the path ends with "[]" the value at the path must
+// be an array and fn is called with each element in turn. An empty path
+// refers to the whole body.
+//
+// The body is parsed one token at a time, values that are not on the
+// path are skipped without being decoded and the body is not read beyond
+// the end of the selected value, so only a single element is held in
+// memory at once. If a key in the path is not present, or a value on the
+// path is null, then fn is not called. If fn returns an error then
+// parsing stops and the error is returned.
+//
+// DecodeResponsePath decodes the response body from the character set
+// specified in the response's Content-Type header before parsing the
+// JSON value. A body that ends part way through the document results in
+// io.ErrUnexpectedEOF.
+func DecodeResponsePath(resp *http.Response, path string, fn func(json.RawMessage) error) error {
+	array := strings.HasSuffix(path, "[]")
+	path = strings.TrimSuffix(path, "[]")
+	var keys []string
+	if path != "" {
+		keys = strings.Split(path, ".")
+	}
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	r, err := charsetReader(resp.Body, charset)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	// started is set once the start of the document has been read, after
+	// which the end of the body is unexpected.
+	started := false
+	for i, key := range keys {
+		found, err := findKey(dec, key, started)
+		if err != nil {
+			return fmt.Errorf("DecodeResponsePath: %q: %w", strings.Join(keys[:i], "."), err)
+		}
+		if !found {
+			return nil
+		}
+		started = true
+	}
+	if !array {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			if started {
+				return unexpectedEOF(err)
+			}
+			return err
+		}
+		if string(v) == "null" {
+			return nil
+		}
+		return fn(v)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		if started {
+			return unexpectedEOF(err)
+		}
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("DecodeResponsePath: %q: value is not an array", path)
+	}
+	for dec.More() {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return unexpectedEOF(err)
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return unexpectedEOF(err)
+}
+
+// findKey reads an object from dec until the value of the given key is
+// the next value in the stream. If the object does not contain the key,
+// or the value is null rather than an object, findKey returns false. If
+// started is true the object is not the start of the document, so the
+// end of the input before it is unexpected.
+func findKey(dec *json.Decoder, key string, started bool) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if started {
+			return false, unexpectedEOF(err)
+		}
+		return false, err
+	}
+	if tok == nil {
+		return false, nil
+	}
+	if tok != json.Delim('{') {
+		return false, errors.New("value is not an object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, unexpectedEOF(err)
+		}
+		if tok == key {
+			return true, nil
+		}
+		if err := skipValue(dec); err != nil {
+			return false, unexpectedEOF(err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return false, unexpectedEOF(err)
+	}
+	return false, nil
+}
+
+// skipValue reads the next value from dec without decoding it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// StreamResponse parses a stream of JSON values, such as a newline
+// delimited JSON (application/x-ndjson) document, from the body of an
+// http.Response. For each value in the stream fn is called with a decode
+// function that stores the value in the value pointed to by its
+// argument, in the same way as json.Unmarshal. If fn does not call
+// decode the value is skipped. If fn returns an error then parsing stops
+// and the error is returned, otherwise StreamResponse returns nil once
+// the end of the body has been reached.
+//
+// The body is read as fn is called, so only a single value is held in
+// memory at once and an unbounded stream can be processed. Any gzip or
+// deflate Content-Encoding is removed and the body is decoded from the
+// character set specified in the response's Content-Type header before
+// the values are parsed. Values may be separated by any JSON white
+// space, not only newlines.
+func StreamResponse(resp *http.Response, fn func(decode func(v interface{}) error) error) error {
+	r, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	charset, err := contentCharset(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	r, err = charsetReader(r, charset)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		decode := func(v interface{}) error {
+			return json.Unmarshal(raw, v)
+		}
+		if err := fn(decode); err != nil {
+			return err
+		}
+	}
+}
+
+// A ResponseStream writes a stream of JSON values as a newline delimited
+// JSON response body.
+type ResponseStream struct {
+	w           http.ResponseWriter
+	opts        MarshalOptions
+	contentType string
+	charset     string
+	err         error
+	started     bool
+}
+
+// NewResponseStream creates a ResponseStream that writes JSON values to
+// w, each followed by a newline. The values are encoded in the
+// character set specified by contentType in the same way as
+// WriteResponse. If contentType is empty then
+// "application/x-ndjson;charset=utf-8" is used. The newline following
+// each value is always a single byte, so the character set must be
+// compatible with ASCII.
+//
+// The Content-Type header is set when the first value is written. The
+// length of the body is not known in advance so it is sent using chunked
+// transfer encoding, callers must not set the Content-Length header
+// themselves.
+func NewResponseStream(w http.ResponseWriter, contentType string) *ResponseStream {
+	return MarshalOptions{}.NewResponseStream(w, contentType)
+}
+
+// NewResponseStream creates a ResponseStream in the same way as the
+// NewResponseStream function, using the options in o. The Indent option
+// is ignored, as each value must be written on a single line.
+func (o MarshalOptions) NewResponseStream(w http.ResponseWriter, contentType string) *ResponseStream {
+	if contentType == "" {
+		contentType = "application/x-ndjson;charset=utf-8"
+	}
+	charset, err := contentCharset(contentType)
+	o.Indent = ""
+	return &ResponseStream{
+		w:           w,
+		opts:        o,
+		contentType: contentType,
+		charset:     charset,
+		err:         err,
+	}
+}
+
+// Encode writes the JSON encoding of v, followed by a newline, to the
+// stream. If the http.ResponseWriter implements http.Flusher the value
+// is flushed to the client before Encode returns. If v cannot be encoded
+// nothing is written. If the content type of the stream has more than
+// one charset every call returns an error matching ErrDuplicateCharset.
+func (s *ResponseStream) Encode(v interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := s.opts.marshalTo(buf, s.charset, v); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	if !s.started {
+		s.w.Header().Set("Content-Type", s.contentType)
+		s.started = true
+	}
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// MarshalRequestStream creates a new http.Request with the given method
+// and URL and a body containing the JSON encoding of v, in the same way
+// as MarshalRequest, except that v is encoded as the body is read,
+// rather than before the request is created.
+//
+// The request has a Content-Length of -1, so that net/http sends the
+// body using chunked transfer encoding, which HTTP/1.0 servers do not
+// support. The request does not have a GetBody method, so it cannot be
+// sent again after a redirect that requires the body, or be retried. If
+// v cannot be encoded, the error is returned when the body is read,
+// which causes the transport to abort the request and return the error
+// from the client's Do method. As with json.Encoder, the encoded value
+// is followed by a newline.
+//
+// If v is nil then the request will have no body.
+func MarshalRequestStream(method, url, contentType string, v interface{}) (*http.Request, error) {
+	return MarshalOptions{}.MarshalRequestStream(method, url, contentType, v)
+}
+
+// MarshalRequestStream creates a new http.Request in the same way as
+// the MarshalRequestStream function, using the options in o. Indent,
+// IndentPrefix and DisableHTMLEscape are applied, the other options are
+// not supported by a streamed body.
+func (o MarshalOptions) MarshalRequestStream(method, url, contentType string, v interface{}) (*http.Request, error) {
+	contentType = o.valueContentType(contentType, v)
+	if v == nil {
+		return http.NewRequest(method, url, nil)
+	}
+	charset, err := contentCharset(contentType)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := o.encoding(charset)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	body := &streamBody{
+		o:   o,
+		enc: enc,
+		v:   v,
+		pr:  pr,
+		pw:  pw,
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// A streamBody is a request body that encodes its value as it is read.
+// The encoding is only started by the first call to Read, so that a
+// request that is never sent does not leave a goroutine blocked writing
+// to the pipe.
+type streamBody struct {
+	o   MarshalOptions
+	enc encoding.Encoding
+	v   interface{}
+
+	once sync.Once
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+}
+
+// Read implements io.Reader.
+func (b *streamBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { go b.encode() })
+	return b.pr.Read(p)
+}
+
+// Close implements io.Closer. Closing the body stops any encoding in
+// progress.
+func (b *streamBody) Close() error {
+	return b.pr.Close()
+}
+
+// encode writes the encoding of the value to the pipe, closing it with
+// any error encountered so that the error is returned from Read.
+func (b *streamBody) encode() {
+	var w io.Writer = b.pw
+	var tw *transform.Writer
+	if b.enc != nil {
+		tw = transform.NewWriter(b.pw, &jsonTransformer{e: b.enc.NewEncoder()})
+		w = tw
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!b.o.DisableHTMLEscape)
+	if b.o.Indent != "" {
+		enc.SetIndent(b.o.IndentPrefix, b.o.Indent)
+	}
+	err := enc.Encode(b.v)
+	if err == nil && tw != nil {
+		err = tw.Close()
+	}
+	b.pw.CloseWithError(err)
+}
@@ -0,0 +1,654 @@
+package httpjson_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+var decodeArrayLimitTests = []struct {
+	name        string
+	contentType string
+	body        string
+	limit       int
+	policy      httpjson.ArrayLimitPolicy
+	expectError string
+	expectErrIs error
+	expectValue []testValue
+}{{
+	name:        "under_limit",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"},{"s":"b"}]`,
+	limit:       3,
+	expectValue: []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:        "at_limit",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"},{"s":"b"}]`,
+	limit:       2,
+	expectValue: []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:        "over_limit",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"},{"s":"b"},{"s":"c"}]`,
+	limit:       2,
+	expectError: `too many array elements`,
+}, {
+	name:        "over_limit_truncate",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"},{"s":"b"},{"s":"c"}]`,
+	limit:       2,
+	policy:      httpjson.ArrayLimitTruncate,
+	expectValue: []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:        "empty",
+	contentType: "application/json;charset=utf-8",
+	body:        `[]`,
+	limit:       2,
+	expectValue: []testValue{},
+}, {
+	name:        "null",
+	contentType: "application/json;charset=utf-8",
+	body:        `null`,
+	limit:       2,
+	expectValue: nil,
+}, {
+	name:        "iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        "[{\"s\":\"\\u263a\xa3\"}]",
+	limit:       2,
+	expectValue: []testValue{{S: "☺£"}},
+}, {
+	name:        "not_array",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"s":"a"}`,
+	limit:       2,
+	expectError: `DecodeArrayLimit: value is not an array`,
+}, {
+	name:        "bad_element",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":1}]`,
+	limit:       2,
+	expectError: `json: cannot unmarshal number into Go struct field testValue.s of type string`,
+}, {
+	name:        "truncated",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"}`,
+	limit:       2,
+	expectErrIs: io.ErrUnexpectedEOF,
+}, {
+	name:        "truncated_element",
+	contentType: "application/json;charset=utf-8",
+	body:        `[{"s":"a"},{"s":`,
+	limit:       2,
+	expectErrIs: io.ErrUnexpectedEOF,
+}}
+
+func TestDecodeArrayLimit(t *testing.T) {
+	for _, test := range decodeArrayLimitTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type": []string{test.contentType},
+				},
+				Body: io.NopCloser(strings.NewReader(test.body)),
+			}
+			v := []testValue{{S: "unchanged"}}
+			err := httpjson.DecodeArrayLimit(resp, &v, test.limit, test.policy)
+			if test.expectError != "" || test.expectErrIs != nil {
+				if test.expectError != "" {
+					qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				}
+				if test.expectErrIs != nil {
+					qt.Check(t, err, qt.ErrorIs, test.expectErrIs)
+				}
+				qt.Check(t, v, qt.DeepEquals, []testValue{{S: "unchanged"}})
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v, qt.DeepEquals, test.expectValue)
+		})
+	}
+}
+
+func TestDecodeArrayLimitNotSlice(t *testing.T) {
+	resp := &http.Response{
+		Body: io.NopCloser(strings.NewReader(`[]`)),
+	}
+	var v testValue
+	err := httpjson.DecodeArrayLimit(resp, &v, 1, httpjson.ArrayLimitError)
+	qt.Check(t, err, qt.ErrorMatches, `DecodeArrayLimit: v must be a non-nil pointer to a slice`)
+}
+
+const decodeResponsePathDocument = `{
+	"meta": {"count": 2, "items": ["not", "these"]},
+	"data": {
+		"total": 2,
+		"items": [{"s":"a"}, {"s":"b\u263a"}],
+		"next": null
+	},
+	"links": [{"rel": "next"}]
+}`
+
+var decodeResponsePathTests = []struct {
+	name        string
+	contentType string
+	body        string
+	path        string
+	expectError string
+	expectErrIs error
+	expectValue []string
+}{{
+	name:        "nested_array",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.items[]",
+	expectValue: []string{`{"s":"a"}`, `{"s":"b\u263a"}`},
+}, {
+	name:        "nested_value",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.items",
+	expectValue: []string{`[{"s":"a"}, {"s":"b\u263a"}]`},
+}, {
+	name:        "nested_number",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "meta.count",
+	expectValue: []string{`2`},
+}, {
+	name:        "root",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"s":"a"}`,
+	path:        "",
+	expectValue: []string{`{"s":"a"}`},
+}, {
+	name:        "root_array",
+	contentType: "application/json;charset=utf-8",
+	body:        `[1, 2, 3]`,
+	path:        "[]",
+	expectValue: []string{`1`, `2`, `3`},
+}, {
+	name:        "missing_key",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.missing[]",
+}, {
+	name:        "null_value",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.next",
+}, {
+	name:        "null_array",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.next[]",
+}, {
+	name:        "null_object",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.next.items[]",
+}, {
+	name:        "iso-8859-1",
+	contentType: "application/json;charset=iso-8859-1",
+	body:        "{\"a\":[\"\xa3\"]}",
+	path:        "a[]",
+	expectValue: []string{`"£"`},
+}, {
+	name:        "not_object",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data.total.items[]",
+	expectError: `DecodeResponsePath: "data.total": value is not an object`,
+}, {
+	name:        "not_array",
+	contentType: "application/json;charset=utf-8",
+	body:        decodeResponsePathDocument,
+	path:        "data[]",
+	expectError: `DecodeResponsePath: "data": value is not an array`,
+}, {
+	name:        "truncated",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"data":{"items":[{"s":"a"}`,
+	path:        "data.items[]",
+	expectErrIs: io.ErrUnexpectedEOF,
+	expectValue: []string{`{"s":"a"}`},
+}, {
+	name:        "truncated_before_value",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"data":{"items":`,
+	path:        "data.items",
+	expectErrIs: io.ErrUnexpectedEOF,
+}, {
+	name:        "truncated_object",
+	contentType: "application/json;charset=utf-8",
+	body:        `{"meta":{"count":2},"data":{"total":2`,
+	path:        "data.items[]",
+	expectErrIs: io.ErrUnexpectedEOF,
+}}
+
+func TestDecodeResponsePath(t *testing.T) {
+	for _, test := range decodeResponsePathTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type": []string{test.contentType},
+				},
+				Body: io.NopCloser(strings.NewReader(test.body)),
+			}
+			var values []string
+			err := httpjson.DecodeResponsePath(resp, test.path, func(v json.RawMessage) error {
+				values = append(values, string(v))
+				return nil
+			})
+			switch {
+			case test.expectError != "":
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+			case test.expectErrIs != nil:
+				qt.Check(t, err, qt.ErrorIs, test.expectErrIs)
+			default:
+				qt.Check(t, err, qt.IsNil)
+			}
+			qt.Check(t, values, qt.DeepEquals, test.expectValue)
+		})
+	}
+}
+
+func TestDecodeResponsePathStopsReading(t *testing.T) {
+	// The body after the selected array is invalid, it should never be
+	// read.
+	resp := &http.Response{
+		Body: io.NopCloser(strings.NewReader(`{"items":[{"s":"a"},{"s":"b"}],` + strings.Repeat("x", 1<<20))),
+	}
+	var values []testValue
+	err := httpjson.DecodeResponsePath(resp, "items[]", func(raw json.RawMessage) error {
+		var v testValue
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		values = append(values, v)
+		return nil
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, values, qt.DeepEquals, []testValue{{S: "a"}, {S: "b"}})
+}
+
+func TestDecodeResponsePathCallbackError(t *testing.T) {
+	resp := &http.Response{
+		Body: io.NopCloser(strings.NewReader(`[1, 2, 3]`)),
+	}
+	testErr := errors.New("test error")
+	var n int
+	err := httpjson.DecodeResponsePath(resp, "[]", func(json.RawMessage) error {
+		n++
+		return testErr
+	})
+	qt.Check(t, err, qt.Equals, testErr)
+	qt.Check(t, n, qt.Equals, 1)
+}
+
+var streamResponseTests = []struct {
+	name            string
+	contentType     string
+	contentEncoding string
+	body            string
+	expectError     string
+	expectValues    []testValue
+}{{
+	name:         "ndjson",
+	contentType:  "application/x-ndjson",
+	body:         "{\"s\":\"a\"}\n{\"s\":\"☺\"}\n",
+	expectValues: []testValue{{S: "a"}, {S: "☺"}},
+}, {
+	name:         "no_trailing_newline",
+	contentType:  "application/x-ndjson",
+	body:         "{\"s\":\"a\"}\n{\"s\":\"b\"}",
+	expectValues: []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:         "blank_lines",
+	contentType:  "application/x-ndjson",
+	body:         "\n{\"s\":\"a\"}\r\n\n  {\"s\":\"b\"}\n\n",
+	expectValues: []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:         "iso-8859-1",
+	contentType:  "application/x-ndjson;charset=iso-8859-1",
+	body:         "{\"s\":\"\xa3\"}\n{\"s\":\"\\u263a\"}\n",
+	expectValues: []testValue{{S: "£"}, {S: "☺"}},
+}, {
+	name:            "gzip",
+	contentType:     "application/x-ndjson",
+	contentEncoding: "gzip",
+	body:            string(gzipBytes("{\"s\":\"a\"}\n{\"s\":\"b\"}\n")),
+	expectValues:    []testValue{{S: "a"}, {S: "b"}},
+}, {
+	name:        "empty",
+	contentType: "application/x-ndjson",
+}, {
+	name:         "invalid_record",
+	contentType:  "application/x-ndjson",
+	body:         "{\"s\":\"a\"}\n{\"s\":\n",
+	expectError:  `unexpected EOF`,
+	expectValues: []testValue{{S: "a"}},
+}, {
+	name:        "unknown_charset",
+	contentType: "application/x-ndjson;charset=no-such",
+	body:        "{\"s\":\"a\"}\n",
+	expectError: `ianaindex: invalid encoding name`,
+}}
+
+func TestStreamResponse(t *testing.T) {
+	for _, test := range streamResponseTests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{
+					"Content-Type":     {test.contentType},
+					"Content-Encoding": {test.contentEncoding},
+				},
+				Body: io.NopCloser(strings.NewReader(test.body)),
+			}
+			var values []testValue
+			err := httpjson.StreamResponse(resp, func(decode func(interface{}) error) error {
+				var v testValue
+				if err := decode(&v); err != nil {
+					return err
+				}
+				values = append(values, v)
+				return nil
+			})
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+			} else {
+				qt.Check(t, err, qt.IsNil)
+			}
+			qt.Check(t, values, qt.DeepEquals, test.expectValues)
+		})
+	}
+}
+
+func TestStreamResponseSkip(t *testing.T) {
+	resp := &http.Response{
+		Body: io.NopCloser(strings.NewReader("{\"s\":\"a\"}\n[1,2]\n{\"s\":\"b\"}\n")),
+	}
+	var n int
+	var values []testValue
+	err := httpjson.StreamResponse(resp, func(decode func(interface{}) error) error {
+		n++
+		if n == 2 {
+			return nil
+		}
+		var v testValue
+		if err := decode(&v); err != nil {
+			return err
+		}
+		values = append(values, v)
+		return nil
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, n, qt.Equals, 3)
+	qt.Check(t, values, qt.DeepEquals, []testValue{{S: "a"}, {S: "b"}})
+}
+
+func TestStreamResponseUnbounded(t *testing.T) {
+	// The writer never finishes the stream, each record must be
+	// processed as it arrives.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		for {
+			if _, err := pw.Write([]byte("{\"s\":\"a\"}\n")); err != nil {
+				return
+			}
+		}
+	}()
+	resp := &http.Response{Body: pr}
+	testErr := errors.New("test error")
+	var n int
+	err := httpjson.StreamResponse(resp, func(decode func(interface{}) error) error {
+		var v testValue
+		if err := decode(&v); err != nil {
+			return err
+		}
+		if n++; n == 1000 {
+			return testErr
+		}
+		return nil
+	})
+	qt.Check(t, err, qt.Equals, testErr)
+	qt.Check(t, n, qt.Equals, 1000)
+}
+
+var responseStreamTests = []struct {
+	name              string
+	contentType       string
+	values            []interface{}
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "default",
+	values:            []interface{}{testValue{S: "☺"}, []int{1, 2}},
+	expectContentType: "application/x-ndjson;charset=utf-8",
+	expectBody:        "{\"s\":\"☺\"}\n[1,2]\n",
+}, {
+	name:              "us-ascii",
+	contentType:       "application/x-ndjson",
+	values:            []interface{}{testValue{S: "☺"}},
+	expectContentType: "application/x-ndjson",
+	expectBody:        "{\"s\":\"\\u263a\"}\n",
+}, {
+	name:              "iso-8859-1",
+	contentType:       "application/x-ndjson;charset=iso-8859-1",
+	values:            []interface{}{testValue{S: "£"}, testValue{S: "☺"}},
+	expectContentType: "application/x-ndjson;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\"}\n{\"s\":\"\\u263a\"}\n",
+}}
+
+func TestResponseStream(t *testing.T) {
+	for _, test := range responseStreamTests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s := httpjson.NewResponseStream(rr, test.contentType)
+			for _, v := range test.values {
+				err := s.Encode(v)
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, rr.Flushed, qt.IsTrue)
+			}
+			resp := rr.Result()
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, resp.Header.Get("Content-Length"), qt.Equals, "")
+			buf, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(buf), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestResponseStreamEncodeError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	s := httpjson.NewResponseStream(rr, "")
+	err := s.Encode(make(chan int))
+	qt.Check(t, err, qt.ErrorMatches, `json: unsupported type: chan int`)
+	qt.Check(t, rr.Body.Len(), qt.Equals, 0)
+}
+
+func TestResponseStreamIndent(t *testing.T) {
+	rr := httptest.NewRecorder()
+	s := httpjson.MarshalOptions{Indent: "  ", DisableHTMLEscape: true}.NewResponseStream(rr, "")
+	err := s.Encode(map[string]string{"a": "<b>"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, rr.Body.String(), qt.Equals, "{\"a\":\"<b>\"}\n")
+}
+
+func TestResponseStreamClient(t *testing.T) {
+	next := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := httpjson.NewResponseStream(w, "")
+		for i := 0; i < 3; i++ {
+			if err := s.Encode(testValue{S: strconv.Itoa(i)}); err != nil {
+				return
+			}
+			// Wait for the client to receive each value before
+			// sending the next.
+			<-next
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	var values []string
+	err = httpjson.StreamResponse(resp, func(decode func(interface{}) error) error {
+		var v testValue
+		if err := decode(&v); err != nil {
+			return err
+		}
+		values = append(values, v.S)
+		next <- struct{}{}
+		return nil
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, values, qt.DeepEquals, []string{"0", "1", "2"})
+}
+
+var marshalRequestStreamTests = []struct {
+	name              string
+	opts              httpjson.MarshalOptions
+	contentType       string
+	v                 interface{}
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "default",
+	v:                 testValue{S: "☺"},
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        "{\"s\":\"☺\"}\n",
+}, {
+	name:              "iso-8859-1",
+	contentType:       "application/json;charset=iso-8859-1",
+	v:                 testValue{S: "£☺"},
+	expectContentType: "application/json;charset=iso-8859-1",
+	expectBody:        "{\"s\":\"\xa3\\u263a\"}\n",
+}, {
+	name:              "options",
+	opts:              httpjson.MarshalOptions{Indent: "  ", DisableHTMLEscape: true},
+	contentType:       "application/json",
+	v:                 map[string]string{"a": "<b>"},
+	expectContentType: "application/json",
+	expectBody:        "{\n  \"a\": \"<b>\"\n}\n",
+}}
+
+func TestMarshalRequestStream(t *testing.T) {
+	for _, test := range marshalRequestStreamTests {
+		t.Run(test.name, func(t *testing.T) {
+			type request struct {
+				contentType      string
+				contentLength    int64
+				transferEncoding []string
+				body             string
+			}
+			reqc := make(chan request, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				reqc <- request{
+					contentType:      req.Header.Get("Content-Type"),
+					contentLength:    req.ContentLength,
+					transferEncoding: req.TransferEncoding,
+					body:             string(body),
+				}
+			}))
+			defer srv.Close()
+
+			req, err := test.opts.MarshalRequestStream("POST", srv.URL, test.contentType, test.v)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, req.ContentLength, qt.Equals, int64(-1))
+			qt.Check(t, req.GetBody, qt.IsNil)
+			resp, err := http.DefaultClient.Do(req)
+			qt.Assert(t, err, qt.IsNil)
+			resp.Body.Close()
+			got := <-reqc
+			qt.Check(t, got.contentType, qt.Equals, test.expectContentType)
+			qt.Check(t, got.contentLength, qt.Equals, int64(-1))
+			qt.Check(t, got.transferEncoding, qt.DeepEquals, []string{"chunked"})
+			qt.Check(t, got.body, qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestMarshalRequestStreamNil(t *testing.T) {
+	req, err := httpjson.MarshalRequestStream("GET", "http://example.com", "", nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.Body, qt.IsNil)
+	qt.Check(t, req.Header.Get("Content-Type"), qt.Equals, "")
+}
+
+func TestMarshalRequestStreamUnsupportedCharset(t *testing.T) {
+	_, err := httpjson.MarshalRequestStream("POST", "http://example.com", "application/json;charset=unknown", testValue{})
+	qt.Check(t, err, qt.ErrorMatches, `ianaindex: invalid encoding name`)
+}
+
+func TestMarshalRequestStreamEncodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+	}))
+	defer srv.Close()
+
+	req, err := httpjson.MarshalRequestStream("POST", srv.URL, "", make(chan int))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = http.DefaultClient.Do(req)
+	qt.Check(t, err, qt.ErrorMatches, `Post ".*": json: unsupported type: chan int`)
+}
+
+func TestMarshalRequestStreamUnread(t *testing.T) {
+	req, err := httpjson.MarshalRequestStream("POST", "http://example.com", "", testValue{S: "a"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, req.Body.Close(), qt.IsNil)
+	_, err = req.Body.Read(make([]byte, 10))
+	qt.Check(t, err, qt.Equals, io.ErrClosedPipe)
+}
+
+func TestStreamDuplicateCharset(t *testing.T) {
+	const contentType = "application/json; charset=iso-8859-1; charset=utf-8"
+	newResponse := func(body string) *http.Response {
+		return &http.Response{
+			Header: http.Header{"Content-Type": {contentType}},
+			Body:   io.NopCloser(strings.NewReader(body)),
+		}
+	}
+	var values []testValue
+	err := httpjson.DecodeArrayLimit(newResponse(`[{"s":"a"}]`), &values, 10, httpjson.ArrayLimitError)
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+	qt.Check(t, values, qt.IsNil)
+
+	called := false
+	err = httpjson.DecodeResponsePath(newResponse(`{"items":[{"s":"a"}]}`), "items", func(json.RawMessage) error {
+		called = true
+		return nil
+	})
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+	qt.Check(t, called, qt.IsFalse)
+
+	err = httpjson.StreamResponse(newResponse(`{"s":"a"}`), func(decode func(v interface{}) error) error {
+		called = true
+		return nil
+	})
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+	qt.Check(t, called, qt.IsFalse)
+
+	resp := newResponse("data: {}\n\n")
+	resp.Header.Set("Content-Type", "text/event-stream; charset=iso-8859-1; charset=utf-8")
+	err = httpjson.StreamEvents(resp, func(httpjson.Event) error {
+		called = true
+		return nil
+	})
+	qt.Check(t, err, qt.ErrorIs, httpjson.ErrDuplicateCharset)
+	qt.Check(t, called, qt.IsFalse)
+}
@@ -0,0 +1,103 @@
+package httpjson
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// A Timing contains the durations of the phases of an HTTP request made
+// by a Client. Phases that did not happen, such as connecting when an
+// existing connection was reused, have a zero duration.
+type Timing struct {
+	// Start is the time at which the request was started.
+	Start time.Time
+
+	// DNS is the time taken to look up the host name.
+	DNS time.Duration
+
+	// Connect is the time taken to establish the network connection.
+	Connect time.Duration
+
+	// TLSHandshake is the time taken to perform the TLS handshake.
+	TLSHandshake time.Duration
+
+	// FirstByte is the time from the start of the request until the
+	// first byte of the response was received.
+	FirstByte time.Duration
+
+	// ConnReused is true if the request was sent on a previously used
+	// connection.
+	ConnReused bool
+}
+
+// A timingTrace collects a Timing from the events reported by an
+// httptrace.ClientTrace. The trace functions may be called concurrently.
+type timingTrace struct {
+	mu           sync.Mutex
+	timing       Timing
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+}
+
+func newTimingTrace() *timingTrace {
+	return &timingTrace{
+		timing: Timing{Start: time.Now()},
+	}
+}
+
+// clientTrace returns the httptrace.ClientTrace that records events in
+// t.
+func (t *timingTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.DNS = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(_, _ string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Connect = time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.TLSHandshake = time.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.ConnReused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.FirstByte = time.Since(t.timing.Start)
+		},
+	}
+}
+
+// get returns the Timing recorded so far.
+func (t *timingTrace) get() Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timing
+}
@@ -0,0 +1,59 @@
+package httpjson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestClientOnTiming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		valueHandler{v: testValue{S: "test message ☺"}}.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	var timings []httpjson.Timing
+	var statuses []int
+	cl := httpjson.Client{
+		OnTiming: func(resp *http.Response, timing httpjson.Timing) {
+			statuses = append(statuses, resp.StatusCode)
+			timings = append(timings, timing)
+		},
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, resp.S, qt.Equals, "test message ☺")
+	qt.Assert(t, timings, qt.HasLen, 1)
+	qt.Check(t, statuses, qt.DeepEquals, []int{http.StatusOK})
+	qt.Check(t, timings[0].Start.IsZero(), qt.IsFalse)
+	qt.Check(t, timings[0].FirstByte >= 10*time.Millisecond, qt.IsTrue, qt.Commentf("FirstByte %v", timings[0].FirstByte))
+	qt.Check(t, timings[0].ConnReused, qt.IsFalse)
+}
+
+func TestClientOnTimingResponseError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	var timings []httpjson.Timing
+	var statuses []int
+	cl := httpjson.Client{
+		OnTiming: func(resp *http.Response, timing httpjson.Timing) {
+			statuses = append(statuses, resp.StatusCode)
+			timings = append(timings, timing)
+		},
+	}
+
+	var resp testValue
+	err := cl.Get(context.Background(), srv.URL, &resp)
+	qt.Check(t, err, qt.ErrorMatches, `404 page not found`)
+	qt.Assert(t, timings, qt.HasLen, 1)
+	qt.Check(t, statuses, qt.DeepEquals, []int{http.StatusNotFound})
+	qt.Check(t, timings[0].FirstByte > 0, qt.IsTrue)
+}
@@ -0,0 +1,106 @@
+package httpjson
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// SetTrailer declares trailers on req that are sent after the request
+// body. The trailer keys are declared when SetTrailer is called, as
+// required by net/http, and fn is called to set their values once the
+// transport has read the whole body. If w is not nil then the body is
+// written to w as it is read, so that, for example, a checksum of the
+// body can be included in the trailers.
+//
+// Trailers can only be sent using chunked transfer encoding, so
+// SetTrailer sets the ContentLength of req to -1. If req has a GetBody
+// method it is replaced with one that also sets the trailers. If w has a
+// Reset method, as hash.Hash does, then it is called before the body is
+// read again.
+//
+// When an http.Client follows a 307 or 308 redirect it sends the body
+// again but not the trailer, so the trailer is lost unless the client's
+// CheckRedirect function is RedirectTrailer, or calls it.
+func SetTrailer(req *http.Request, keys []string, w io.Writer, fn func(trailer http.Header)) {
+	if req.Trailer == nil {
+		req.Trailer = make(http.Header)
+	}
+	for _, k := range keys {
+		req.Trailer[http.CanonicalHeaderKey(k)] = nil
+	}
+	trailer := req.Trailer
+	wrap := func(body io.ReadCloser) io.ReadCloser {
+		return &trailerBody{
+			ReadCloser: body,
+			w:          w,
+			done: func() {
+				fn(trailer)
+			},
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = wrap(req.Body)
+	}
+	req.ContentLength = -1
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			if r, ok := w.(interface{ Reset() }); ok {
+				r.Reset()
+			}
+			return wrap(body), nil
+		}
+	}
+}
+
+// A trailerBody is a request body that calls done once the end of the
+// body has been reached.
+type trailerBody struct {
+	io.ReadCloser
+	w    io.Writer
+	done func()
+	eof  bool
+}
+
+// Read implements io.Reader.
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.w != nil {
+		if _, werr := b.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err == io.EOF && !b.eof {
+		b.eof = true
+		b.done()
+	}
+	return n, err
+}
+
+// maxRedirects is the number of redirects followed by RedirectTrailer,
+// the same as the default policy of http.Client.
+const maxRedirects = 10
+
+// RedirectTrailer is an http.Client CheckRedirect function that sends
+// the trailer declared by SetTrailer with a request that is being
+// redirected, which http.Client does not otherwise do. In the same way
+// as the default policy it stops after 10 consecutive redirects. A
+// CheckRedirect function implementing another policy can call
+// RedirectTrailer to keep the trailer.
+func RedirectTrailer(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	// The trailer map is shared with the function that sets its
+	// values, which is called when the new request's body, obtained
+	// from GetBody, has been read.
+	prev := via[len(via)-1]
+	if prev.Trailer != nil && req.Body != nil && req.Body != http.NoBody {
+		req.Trailer = prev.Trailer
+	}
+	return nil
+}
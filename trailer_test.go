@@ -0,0 +1,217 @@
+package httpjson_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+func TestSetTrailer(t *testing.T) {
+	type received struct {
+		body, checksum string
+		chunked        bool
+	}
+	var got []received
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/", http.StatusPermanentRedirect)
+			return
+		}
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, received{
+			body:     string(buf),
+			checksum: req.Trailer.Get("X-Checksum"),
+			chunked:  len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked",
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: httpjson.RedirectTrailer}
+	for _, path := range []string{"/", "/redirect"} {
+		got = nil
+		req, err := httpjson.MarshalRequest("POST", srv.URL+path, "", testValue{S: "test message ☺"})
+		qt.Assert(t, err, qt.IsNil)
+		h := sha256.New()
+		httpjson.SetTrailer(req, []string{"x-checksum"}, h, checksumTrailer(h))
+		resp, err := client.Do(req)
+		qt.Assert(t, err, qt.IsNil)
+		resp.Body.Close()
+		qt.Check(t, resp.StatusCode, qt.Equals, http.StatusNoContent)
+		qt.Assert(t, got, qt.HasLen, 1)
+		qt.Check(t, got[0].body, qt.Equals, `{"s":"test message ☺"}`)
+		qt.Check(t, got[0].chunked, qt.IsTrue)
+		sum := sha256.Sum256([]byte(got[0].body))
+		qt.Check(t, got[0].checksum, qt.Equals, hex.EncodeToString(sum[:]))
+	}
+
+	// Without RedirectTrailer the trailer is not sent with the
+	// redirected request.
+	got = nil
+	req, err := httpjson.MarshalRequest("POST", srv.URL+"/redirect", "", testValue{S: "test message ☺"})
+	qt.Assert(t, err, qt.IsNil)
+	h := sha256.New()
+	httpjson.SetTrailer(req, []string{"x-checksum"}, h, checksumTrailer(h))
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	resp.Body.Close()
+	qt.Assert(t, got, qt.HasLen, 1)
+	qt.Check(t, got[0].checksum, qt.Equals, "")
+}
+
+func TestRedirectTrailerLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: httpjson.RedirectTrailer}
+	_, err := client.Get(srv.URL)
+	qt.Check(t, err, qt.ErrorMatches, `.*stopped after 10 redirects`)
+}
+
+func TestSetTrailerStreamingBody(t *testing.T) {
+	var body, checksum string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = string(buf)
+		checksum = req.Trailer.Get("X-Checksum")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	req, err := httpjson.MarshalMultipartRequest("POST", srv.URL, "metadata", "", testValue{S: "☺"}, httpjson.MultipartFile{
+		FieldName: "file",
+		FileName:  "file.txt",
+		Body:      strings.NewReader(strings.Repeat("x", 100000)),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	h := sha256.New()
+	httpjson.SetTrailer(req, []string{"X-Checksum"}, h, checksumTrailer(h))
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	resp.Body.Close()
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusNoContent)
+	sum := sha256.Sum256([]byte(body))
+	qt.Check(t, checksum, qt.Equals, hex.EncodeToString(sum[:]))
+}
+
+func checksumTrailer(h hash.Hash) func(http.Header) {
+	return func(trailer http.Header) {
+		trailer.Set("X-Checksum", hex.EncodeToString(h.Sum(nil)))
+	}
+}
+
+var unmarshalResponseTrailerTests = []struct {
+	name            string
+	contentEncoding string
+	body            string
+	status          string
+	expectError     string
+}{{
+	name:   "complete",
+	body:   `{"s":"☺"}`,
+	status: "complete",
+}, {
+	name:            "gzip",
+	contentEncoding: "gzip",
+	body:            string(gzipBytes(`{"s":"☺"}`)),
+	status:          "complete",
+}, {
+	name:            "deflate",
+	contentEncoding: "deflate",
+	body:            string(zlibBytes(`{"s":"☺"}`)),
+	status:          "complete",
+}, {
+	name:        "failed",
+	body:        `{"s":"☺"}`,
+	status:      "failed",
+	expectError: `stream status "failed"`,
+}, {
+	name:        "missing",
+	body:        `{"s":"☺"}`,
+	expectError: `stream status ""`,
+}}
+
+func TestUnmarshalResponseTrailer(t *testing.T) {
+	for _, test := range unmarshalResponseTrailerTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Trailer", "X-Stream-Status")
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", test.contentEncoding)
+				io.WriteString(w, test.body)
+				w.(http.Flusher).Flush()
+				if test.status != "" {
+					w.Header().Set("X-Stream-Status", test.status)
+				}
+			}))
+			defer srv.Close()
+
+			// The request sets its own Accept-Encoding so that the
+			// transport doesn't decompress the response.
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			qt.Assert(t, err, qt.IsNil)
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+			resp, err := http.DefaultClient.Do(req)
+			qt.Assert(t, err, qt.IsNil)
+			defer resp.Body.Close()
+			qt.Check(t, resp.ContentLength, qt.Equals, int64(-1))
+
+			opts := httpjson.UnmarshalOptions{
+				CheckTrailer: func(trailer http.Header) error {
+					if status := trailer.Get("X-Stream-Status"); status != "complete" {
+						return fmt.Errorf("stream status %q", status)
+					}
+					return nil
+				},
+			}
+			var v testValue
+			err = opts.UnmarshalResponse(resp, &v)
+			if test.expectError != "" {
+				qt.Check(t, err, qt.ErrorMatches, test.expectError)
+				qt.Check(t, v, qt.Equals, testValue{})
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, v, qt.Equals, testValue{S: "☺"})
+		})
+	}
+}
+
+func TestUnmarshalResponsePopulatesTrailer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"s":"☺"}`)
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	var v testValue
+	err = httpjson.UnmarshalResponse(resp, &v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v, qt.Equals, testValue{S: "☺"})
+	qt.Check(t, resp.Trailer.Get("X-Checksum"), qt.Equals, "abc")
+}
@@ -0,0 +1,95 @@
+package httpjson
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// A Transport is an http.RoundTripper that handles the transport level
+// details of JSON responses, so that JSON can be used with an ordinary
+// http.Client and composed with other RoundTrippers, such as those
+// adding tracing or metrics.
+//
+// Requests are passed to Base unchanged, other than an Accept header of
+// "application/json" being added to requests without one. The body of a
+// request is not encoded by a Transport, a typed value can be encoded
+// into a standard *http.Request using MarshalRequestContext, which
+// encodes the body into the character set of the given content type and
+// sets the Content-Type header to match, then sent with any http.Client:
+//
+//	req, err := httpjson.MarshalRequestContext(ctx, "POST", url, "application/json;charset=iso-8859-1", v)
+//	...
+//	resp, err := client.Do(req)
+//
+// The body of a response with a JSON content type, as determined by
+// IsJSONContentType, has any gzip or deflate Content-Encoding removed
+// and is decoded from the character set in its Content-Type into UTF-8.
+// The Content-Type of such a response is rewritten to have a charset of
+// "utf-8", and the Content-Encoding and Content-Length headers are
+// removed, so that the body can be decoded with encoding/json, or
+// UnmarshalResponse, without any further processing. Responses with any
+// other content type are returned unchanged.
+type Transport struct {
+	// Base is the RoundTripper used to make requests. If this is nil
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// IsJSONContentType is used to determine if a response contains a
+	// JSON-encoded body. If this is nil the IsJSONContentType function
+	// is used.
+	IsJSONContentType func(contentType string) bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept") == "" {
+		// A RoundTripper must not modify the request.
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept", "application/json")
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	isJSON := t.IsJSONContentType
+	if isJSON == nil {
+		isJSON = IsJSONContentType
+	}
+	if !isJSON(contentType) {
+		return resp, nil
+	}
+	mt, params, err := ParseContentType(contentType)
+	if err != nil {
+		return resp, nil
+	}
+	coding := resp.Header.Get("Content-Encoding")
+	if coding == "" && (params["charset"] == "" || strings.EqualFold(params["charset"], "utf-8")) {
+		return resp, nil
+	}
+	r, err := decompress(resp.Body, coding)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	r, err = charsetReader(r, params["charset"])
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	params["charset"] = "utf-8"
+	resp.Header.Set("Content-Type", mime.FormatMediaType(mt, params))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	if coding != "" {
+		resp.Uncompressed = true
+	}
+	resp.Body = readCloser{Reader: r, Closer: resp.Body}
+	return resp, nil
+}
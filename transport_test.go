@@ -0,0 +1,137 @@
+package httpjson_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mhilton/httpjson"
+)
+
+// A roundTripperFunc is an http.RoundTripper implemented by a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var transportTests = []struct {
+	name              string
+	contentType       string
+	contentEncoding   string
+	body              []byte
+	expectContentType string
+	expectBody        string
+}{{
+	name:              "utf-8",
+	contentType:       "application/json;charset=utf-8",
+	body:              []byte(`{"s":"☺"}`),
+	expectContentType: "application/json;charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "iso-8859-1",
+	contentType:       "application/json;charset=iso-8859-1",
+	body:              []byte("{\"s\":\"\xa3\"}"),
+	expectContentType: "application/json; charset=utf-8",
+	expectBody:        `{"s":"£"}`,
+}, {
+	name:              "malformed_content_type",
+	contentType:       "charset=iso-8859-1; application/json;;",
+	body:              []byte("{\"s\":\"\xa3\"}"),
+	expectContentType: "application/json; charset=utf-8",
+	expectBody:        `{"s":"£"}`,
+}, {
+	name:              "gzip",
+	contentType:       "application/vnd.test+json",
+	contentEncoding:   "gzip",
+	body:              gzipped(`{"s":"☺"}`),
+	expectContentType: "application/vnd.test+json; charset=utf-8",
+	expectBody:        `{"s":"☺"}`,
+}, {
+	name:              "not_json",
+	contentType:       "text/plain;charset=iso-8859-1",
+	body:              []byte("\xa3"),
+	expectContentType: "text/plain;charset=iso-8859-1",
+	expectBody:        "\xa3",
+}}
+
+func TestTransport(t *testing.T) {
+	for _, test := range transportTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				if test.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				w.Write(test.body)
+			}))
+			defer srv.Close()
+
+			var accept []string
+			client := &http.Client{
+				Transport: &httpjson.Transport{
+					Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						accept = append(accept, req.Header.Get("Accept"))
+						return http.DefaultTransport.RoundTrip(req)
+					}),
+				},
+			}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			qt.Assert(t, err, qt.IsNil)
+			if test.contentEncoding != "" {
+				// Stop http.Transport removing the encoding itself.
+				req.Header.Set("Accept-Encoding", test.contentEncoding)
+			}
+			resp, err := client.Do(req)
+			qt.Assert(t, err, qt.IsNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, test.expectContentType)
+			qt.Check(t, resp.Header.Get("Content-Encoding"), qt.Equals, "")
+			qt.Check(t, string(body), qt.Equals, test.expectBody)
+			qt.Check(t, accept, qt.DeepEquals, []string{"application/json"})
+			qt.Check(t, req.Header.Get("Accept"), qt.Equals, "")
+		})
+	}
+}
+
+func TestTransportMarshalRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var v testValue
+		if err := httpjson.UnmarshalRequest(req, &v); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		httpjson.WriteResponse(w, http.StatusOK, "application/json;charset=iso-8859-1", v)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &httpjson.Transport{}}
+	req, err := httpjson.MarshalRequest("POST", srv.URL, "application/json;charset=iso-8859-1", testValue{S: "£"})
+	qt.Assert(t, err, qt.IsNil)
+	resp, err := client.Do(req.WithContext(context.Background()))
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	var v testValue
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, v.S, qt.Equals, "£")
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}